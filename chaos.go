@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+// The chaos actions, which are injected on the dependencies of a container
+const (
+	// ChaosDisconnect disconnects the stopped container from a network.
+	ChaosDisconnect = "disconnect"
+
+	// ChaosPause pauses a dependency container.
+	ChaosPause = "pause"
+
+	// ChaosStop stops a dependency container, without giving it time to terminate
+	// gracefully, as if it had gone away.
+	ChaosStop = "stop"
+)
+
+// When chaos is injected, relative to the stop signal
+const (
+	ChaosBefore = "before"
+	ChaosAfter  = "after"
+)
+
+// Chaos is a failure injected on a dependency of a container while it is stopped
+type Chaos struct {
+	Action string
+
+	// Target is a network for ChaosDisconnect and a container otherwise
+	Target string
+}
+
+func (ch Chaos) String() string {
+	return ch.Action + ":" + ch.Target
+}
+
+// parseChaos parses chaos actions such as "pause:postgres"
+func parseChaos(values []string) ([]Chaos, error) {
+	var chaos []Chaos
+
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("chaos %q must be in the form ACTION:TARGET", value)
+		}
+
+		switch parts[0] {
+		case ChaosDisconnect, ChaosPause, ChaosStop:
+		default:
+			return nil, fmt.Errorf("unknown chaos action %q, must be one of %s, %s or %s", parts[0], ChaosDisconnect, ChaosPause, ChaosStop)
+		}
+
+		chaos = append(chaos, Chaos{Action: parts[0], Target: parts[1]})
+	}
+
+	return chaos, nil
+}
+
+// injectChaos injects all chaos of the input on the dependencies of a container, and
+// returns a function that undoes it. Whatever was injected is undone on error.
+func injectChaos(ctx context.Context, in Input, c string) (func(), error) {
+	var undo []func()
+
+	restore := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+
+	for _, ch := range in.Chaos {
+		u, err := ch.inject(ctx, in, c)
+
+		if err != nil {
+			restore()
+			return nil, fmt.Errorf("failed to inject chaos %s: %w", ch, err)
+		}
+
+		undo = append(undo, u)
+	}
+
+	return restore, nil
+}
+
+func (ch Chaos) inject(ctx context.Context, in Input, c string) (func(), error) {
+	docker := in.Docker
+
+	switch ch.Action {
+	case ChaosDisconnect:
+		json, err := docker.ContainerInspect(ctx, c)
+
+		if err != nil {
+			return nil, err
+		}
+
+		endpoint, ok := json.NetworkSettings.Networks[ch.Target]
+
+		if !ok {
+			return nil, fmt.Errorf("container %s is not connected to network %s", json.ID[:12], ch.Target)
+		}
+
+		if err := docker.NetworkDisconnect(ctx, ch.Target, c, true); err != nil {
+			return nil, err
+		}
+
+		// only the settings that were requested can be given back when connecting
+		settings := &network.EndpointSettings{
+			IPAMConfig: endpoint.IPAMConfig,
+			Links:      endpoint.Links,
+			Aliases:    endpoint.Aliases,
+		}
+
+		return func() { _ = docker.NetworkConnect(ctx, ch.Target, c, settings) }, nil
+
+	case ChaosPause:
+		if err := docker.ContainerPause(ctx, ch.Target); err != nil {
+			return nil, err
+		}
+
+		return func() { _ = docker.ContainerUnpause(ctx, ch.Target) }, nil
+
+	case ChaosStop:
+		timeout := time.Duration(0)
+
+		if err := docker.ContainerStop(ctx, ch.Target, &timeout); err != nil {
+			return nil, err
+		}
+
+		return func() { _ = docker.ContainerStart(ctx, ch.Target, types.ContainerStartOptions{}) }, nil
+	}
+
+	return nil, fmt.Errorf("unknown chaos action %q", ch.Action)
+}
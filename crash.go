@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/urfave/cli/v2"
+)
+
+// defaultRecoveryLog matches the log lines that commonly tell about recovering from
+// a crash
+const defaultRecoveryLog = `(?i)recover|replay|crash|corrupt|unclean|inconsisten|repair|journal|\bwal\b|stale|fsck`
+
+// Crash holds the options of the crash command
+type Crash struct {
+	// KillAfter is how long after the container is ready it is killed, so it can be
+	// busy by then, for instance with a before-stop hook generating load
+	KillAfter time.Duration
+
+	// RecoveryLog matches the log lines reported after the restart
+	RecoveryLog *regexp.Regexp
+}
+
+// CrashOutput is the outcome of killing and restarting a container
+type CrashOutput struct {
+	Target  string
+	ID      string
+	ShortID string
+
+	// Recovered tells if the container was ready again after the restart, within
+	// Duration, otherwise Error tells why not
+	Recovered bool
+	Duration  time.Duration
+	Error     string
+
+	// Lines are the log lines since the restart that match the recovery log
+	Lines []string
+}
+
+var crashFlags = []cli.Flag{
+	&cli.DurationFlag{
+		Name:  "kill-after",
+		Usage: "kill containers this `DURATION` after they are ready",
+	},
+	&cli.StringFlag{
+		Name:  "recovery-log",
+		Usage: "report the log lines matching this `REGEX` after the restart",
+		Value: defaultRecoveryLog,
+	},
+}
+
+var crashCommand = &cli.Command{
+	Name:      "crash",
+	Usage:     "kills containers, restarts them with the same volumes and reports whether they recover",
+	UsageText: "grace crash [OPTIONS] [CONTAINER|IMAGE [CONTAINER|IMAGE ...]]",
+	Flags:     commandFlags(crashFlags, runFlags, stopFlags),
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			cli.ShowCommandHelpAndExit(c, "crash", 0)
+		}
+
+		in, err := parseInput(c)
+
+		if err != nil {
+			return err
+		}
+
+		if err := checkReportHooks(in); err != nil {
+			return err
+		}
+
+		crash := Crash{KillAfter: c.Duration("kill-after")}
+
+		if crash.RecoveryLog, err = regexp.Compile(c.String("recovery-log")); err != nil {
+			return err
+		}
+
+		return runCrash(in, crash, os.Stdout)
+	},
+}
+
+func runCrash(in Input, crash Crash, writer io.Writer) error {
+	var data []CrashOutput
+
+	for _, target := range in.Containers {
+		out, err := crashOnce(context.Background(), in, crash, target)
+
+		if err != nil {
+			return err
+		}
+
+		data = append(data, out)
+	}
+
+	writeCrash(writer, data)
+
+	// there is no stop result to give to the hooks
+	for _, out := range data {
+		if err := runHooks(context.Background(), in, HookEvent{Stage: HookAfterReport, ContainerID: out.ID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// crashOnce kills the container of a target and starts it again. The same container
+// is restarted, so it keeps its volumes and its writable layer, as it would after a
+// host crash with a restart policy.
+func crashOnce(ctx context.Context, in Input, crash Crash, target string) (CrashOutput, error) {
+	docker := in.Docker
+
+	c, err := provision(ctx, in, target)
+
+	if err != nil {
+		return CrashOutput{}, err
+	}
+
+	defer cleanup(ctx, in, c)
+
+	// existing containers may not be ready yet either
+	if !in.Mode.fresh() {
+		if _, err := waitReady(ctx, in, c); err != nil {
+			return CrashOutput{}, err
+		}
+	}
+
+	time.Sleep(crash.KillAfter)
+
+	json, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return CrashOutput{}, err
+	}
+
+	out := CrashOutput{Target: target, ID: json.ID, ShortID: json.ID[:12]}
+
+	if err := runHooks(ctx, in, HookEvent{Stage: HookBeforeStop, ContainerID: json.ID}); err != nil {
+		return out, err
+	}
+
+	exited, failed := docker.ContainerWait(ctx, c, container.WaitConditionNotRunning)
+
+	if err := docker.ContainerKill(ctx, c, killSignal); err != nil {
+		return out, err
+	}
+
+	select {
+	case <-exited:
+	case err := <-failed:
+		return out, err
+	}
+
+	restarted := time.Now()
+
+	if err := docker.ContainerStart(ctx, c, types.ContainerStartOptions{}); err != nil {
+		out.Error = err.Error()
+		return out, nil
+	}
+
+	if out.Duration, err = waitReady(ctx, in, c); err != nil {
+		out.Error = err.Error()
+	} else {
+		out.Recovered = true
+	}
+
+	logs, err := readLogs(ctx, docker, c, restarted)
+
+	if err != nil {
+		return out, err
+	}
+
+	for _, line := range strings.Split(logs, "\n") {
+		if crash.RecoveryLog.MatchString(line) {
+			out.Lines = append(out.Lines, strings.TrimRight(line, "\r"))
+		}
+	}
+
+	return out, nil
+}
+
+func writeCrash(writer io.Writer, data []CrashOutput) {
+	table := newTable(writer)
+
+	var rows [][]string
+
+	for _, out := range data {
+		result, duration := "recovered", out.Duration.Round(time.Millisecond).String()
+
+		if !out.Recovered {
+			result, duration = out.Error, "-"
+		}
+
+		rows = append(rows, []string{
+			out.Target,
+			out.ShortID,
+			duration,
+			fmt.Sprint(len(out.Lines)),
+			result,
+		})
+	}
+
+	table.SetHeader([]string{
+		"TARGET", "ID", "RECOVERY", "RECOVERY LOGS", "RESULT",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, out := range data {
+		if len(out.Lines) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nRecovery logs of %s:\n\n%s\n", out.ShortID, strings.Join(out.Lines, "\n"))
+	}
+}
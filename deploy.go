@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/urfave/cli/v2"
+)
+
+// The deploy strategies
+const (
+	// StrategyBlueGreen starts a replacement, switches all traffic to it at once and
+	// then stops the old container.
+	StrategyBlueGreen = "bluegreen"
+
+	// StrategyRolling replaces two replicas one at a time, so one of them always
+	// serves traffic.
+	StrategyRolling = "rolling"
+)
+
+const (
+	// rollingReplicas is how many replicas a rolling deploy replaces
+	rollingReplicas = 2
+
+	// requestTimeout is how long a request through the proxy may take
+	requestTimeout = 5 * time.Second
+
+	// maxDeployErrors is how many distinct request errors are reported
+	maxDeployErrors = 5
+)
+
+// Deploy holds the options of the deploy command
+type Deploy struct {
+	// Image is what the containers are replaced with
+	Image    string
+	Strategy string
+
+	// Traffic is a port and path, such as "8080/", requested through the proxy
+	// during the whole deploy
+	Traffic     string
+	Concurrency int
+
+	// Settle is how long traffic is sent before and after the deploy
+	Settle time.Duration
+}
+
+// DeployPhase counts the requests sent during a phase of a deploy
+type DeployPhase struct {
+	Name     string
+	Requests int
+	Failures int
+}
+
+// DeployOutput is the outcome of replacing the container of a target
+type DeployOutput struct {
+	Target   string
+	Strategy string
+	Phases   []*DeployPhase
+
+	// Stopped are the results of stopping the old containers
+	Stopped []Output
+
+	// Errors are the first distinct reasons requests failed for
+	Errors []string
+}
+
+// Failures counts the requests that failed during the whole deploy
+func (out DeployOutput) Failures() int {
+	var n int
+
+	for _, phase := range out.Phases {
+		n += phase.Failures
+	}
+
+	return n
+}
+
+var deployFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "to",
+		Usage: "replace containers with containers of this `IMAGE`, with the same configuration",
+	},
+	&cli.StringFlag{
+		Name:  "strategy",
+		Usage: "replace containers with this `STRATEGY`, either bluegreen or rolling (two replicas, one at a time)",
+		Value: StrategyBlueGreen,
+	},
+	&cli.StringFlag{
+		Name:  "traffic",
+		Usage: "continuously request this `PORT/PATH` of the containers through the proxy, expecting a 2xx status",
+	},
+	&cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "send traffic from `N` clients at once",
+		Value: 4,
+	},
+	&cli.DurationFlag{
+		Name:  "settle",
+		Usage: "send traffic for this `DURATION` before and after the deploy",
+		Value: time.Second,
+	},
+}
+
+var deployCommand = &cli.Command{
+	Name:      "deploy",
+	Usage:     "replaces containers behind a proxy and reports the requests that failed",
+	UsageText: "grace deploy --to IMAGE --traffic PORT/PATH [OPTIONS] [CONTAINER|IMAGE [CONTAINER|IMAGE ...]]",
+	Flags:     commandFlags(deployFlags, runFlags, []string{"cpu-burners", "burner-image"}),
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			cli.ShowCommandHelpAndExit(c, "deploy", 0)
+		}
+
+		in, err := parseInput(c)
+
+		if err != nil {
+			return err
+		}
+
+		deploy := Deploy{
+			Image:       c.String("to"),
+			Strategy:    c.String("strategy"),
+			Traffic:     c.String("traffic"),
+			Concurrency: c.Int("concurrency"),
+			Settle:      c.Duration("settle"),
+		}
+
+		if deploy.Image == "" || deploy.Traffic == "" {
+			return fmt.Errorf("deploy requires both to and traffic")
+		}
+
+		if deploy.Strategy != StrategyBlueGreen && deploy.Strategy != StrategyRolling {
+			return fmt.Errorf("strategy must be either %s or %s", StrategyBlueGreen, StrategyRolling)
+		}
+
+		if deploy.Concurrency < 1 {
+			return fmt.Errorf("concurrency must be at least 1")
+		}
+
+		// replacements are only ready once they answer the traffic
+		if !in.Readiness.configured() {
+			in.Readiness.HTTP = deploy.Traffic
+		}
+
+		return runDeploy(in, deploy, os.Stdout)
+	},
+}
+
+func runDeploy(in Input, deploy Deploy, writer io.Writer) error {
+	var data []DeployOutput
+
+	for _, target := range in.Containers {
+		out, err := deployTarget(context.Background(), in, deploy, target)
+
+		if err != nil {
+			return err
+		}
+
+		data = append(data, out)
+	}
+
+	writeDeploy(writer, data)
+
+	var all []Output
+
+	for _, out := range data {
+		all = append(all, out.Stopped...)
+	}
+
+	return afterReport(context.Background(), in, all)
+}
+
+// deployTarget puts the container of a target behind the proxy and replaces it,
+// while sending traffic through the proxy
+func deployTarget(ctx context.Context, in Input, deploy Deploy, target string) (DeployOutput, error) {
+	c, err := provision(ctx, in, target)
+
+	if err != nil {
+		return DeployOutput{}, err
+	}
+
+	defer cleanup(ctx, in, c)
+
+	// the replicas and replacements are always removed, whatever the mode
+	fresh := in
+	fresh.Mode = Clone
+
+	olds := []string{c}
+
+	if deploy.Strategy == StrategyRolling {
+		for len(olds) < rollingReplicas {
+			replica, err := create(ctx, fresh, c)
+
+			if err != nil {
+				return DeployOutput{}, err
+			}
+
+			defer cleanup(ctx, fresh, replica)
+
+			olds = append(olds, replica)
+		}
+	}
+
+	p, err := startProxy()
+
+	if err != nil {
+		return DeployOutput{}, err
+	}
+
+	defer p.close()
+
+	for _, old := range olds {
+		if _, err := waitReady(ctx, in, old); err != nil {
+			return DeployOutput{}, err
+		}
+
+		backend, err := backendAddr(ctx, in, deploy, old)
+
+		if err != nil {
+			return DeployOutput{}, err
+		}
+
+		p.add(backend)
+	}
+
+	out := DeployOutput{Target: target, Strategy: deploy.Strategy}
+
+	t := &traffic{out: &out}
+	t.enter("before")
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < deploy.Concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			t.send(p, deploy.Traffic, done)
+		}()
+	}
+
+	var once sync.Once
+
+	stopTraffic := func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+
+	var replacements []string
+
+	// the traffic stops before the replacements are removed, so that it doesn't
+	// fail on them
+	defer func() {
+		stopTraffic()
+
+		for _, replacement := range replacements {
+			cleanup(ctx, fresh, replacement)
+		}
+	}()
+
+	time.Sleep(deploy.Settle)
+
+	// replace starts a replacement for an old container and waits for it to be
+	// ready, returning its backend address
+	replace := func(old string) (string, error) {
+		json, err := in.Docker.ContainerInspect(ctx, old)
+
+		if err != nil {
+			return "", err
+		}
+
+		image, _, err := in.Docker.ImageInspectWithRaw(ctx, json.Image)
+
+		if err != nil {
+			return "", err
+		}
+
+		if err := pullImage(ctx, in.Docker, deploy.Image); err != nil {
+			return "", err
+		}
+
+		config, hostConfig := replacementConfig(json, image.Config, deploy.Image)
+
+		replacement, err := start(ctx, fresh, config, hostConfig)
+
+		if err != nil {
+			return "", err
+		}
+
+		replacements = append(replacements, replacement)
+
+		if _, err := waitReady(ctx, in, replacement); err != nil {
+			return "", err
+		}
+
+		return backendAddr(ctx, in, deploy, replacement)
+	}
+
+	stop := func(old string) error {
+		stopped, err := analyze(ctx, in, target, old)
+		out.Stopped = append(out.Stopped, stopped)
+
+		return err
+	}
+
+	switch deploy.Strategy {
+	case StrategyBlueGreen:
+		t.enter("start new")
+
+		backend, err := replace(c)
+
+		if err != nil {
+			return DeployOutput{}, err
+		}
+
+		p.set(backend)
+
+		t.enter("stop old")
+
+		if err := stop(c); err != nil {
+			return DeployOutput{}, err
+		}
+
+	case StrategyRolling:
+		for i, old := range olds {
+			n := strconv.Itoa(i + 1)
+
+			t.enter("start new " + n)
+
+			backend, err := replace(old)
+
+			if err != nil {
+				return DeployOutput{}, err
+			}
+
+			oldBackend, err := backendAddr(ctx, in, deploy, old)
+
+			if err != nil {
+				return DeployOutput{}, err
+			}
+
+			p.add(backend)
+			p.remove(oldBackend)
+
+			t.enter("stop old " + n)
+
+			if err := stop(old); err != nil {
+				return DeployOutput{}, err
+			}
+		}
+	}
+
+	t.enter("after")
+	time.Sleep(deploy.Settle)
+
+	stopTraffic()
+
+	return out, nil
+}
+
+// backendAddr is the address the proxy sends the traffic of a container to
+func backendAddr(ctx context.Context, in Input, deploy Deploy, c string) (string, error) {
+	json, err := in.Docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return "", err
+	}
+
+	ip := containerIP(json)
+
+	if ip == "" {
+		return "", fmt.Errorf("container %s has no IP address", json.ID[:12])
+	}
+
+	port, _ := splitPortPath(deploy.Traffic)
+
+	return net.JoinHostPort(ip, port), nil
+}
+
+// traffic records the requests sent during each phase of a deploy
+type traffic struct {
+	mu    sync.Mutex
+	out   *DeployOutput
+	phase *DeployPhase
+}
+
+// enter starts a new phase, the following requests are counted in it
+func (t *traffic) enter(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.phase = &DeployPhase{Name: name}
+	t.out.Phases = append(t.out.Phases, t.phase)
+}
+
+// record counts a request, which failed with the given reason unless it is empty
+func (t *traffic) record(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.phase.Requests++
+
+	if reason == "" {
+		return
+	}
+
+	t.phase.Failures++
+
+	if len(t.out.Errors) < maxDeployErrors && !contains(t.out.Errors, reason) {
+		t.out.Errors = append(t.out.Errors, reason)
+	}
+}
+
+// send requests a path through the proxy until done is closed
+func (t *traffic) send(p *proxy, portPath string, done chan struct{}) {
+	_, path := splitPortPath(portPath)
+
+	url := "http://" + p.addr() + path
+
+	client := &http.Client{Timeout: requestTimeout}
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		resp, err := client.Get(url)
+
+		switch {
+		case err != nil:
+			t.record(err.Error())
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			t.record(resp.Status)
+		default:
+			t.record("")
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		time.Sleep(trafficInterval)
+	}
+}
+
+// replacementConfig returns the configuration of a container of a new image
+// replacing an old container. Everything the old image set, such as its command,
+// user or healthcheck, gives way to the new image; only what was set on the old
+// container itself is kept: its environment overrides, ports, networks and mounts.
+// The new version mounts the volumes of the old one, as in a real deploy.
+func replacementConfig(old types.ContainerJSON, oldImage *container.Config, image string) (*container.Config, *container.HostConfig) {
+	var imageEnv []string
+
+	if oldImage != nil {
+		imageEnv = oldImage.Env
+	}
+
+	var env []string
+
+	for _, v := range old.Config.Env {
+		if !contains(imageEnv, v) {
+			env = append(env, v)
+		}
+	}
+
+	config := &container.Config{
+		Image:        image,
+		Env:          env,
+		ExposedPorts: old.Config.ExposedPorts,
+	}
+
+	hostConfig := *old.HostConfig
+
+	// avoid conflicting with the host ports published by the old container, which
+	// keeps running for a while
+	hostConfig.PortBindings = nil
+
+	// we remove the replacement ourselves after the deploy
+	hostConfig.AutoRemove = false
+
+	return config, &hostConfig
+}
+
+func writeDeploy(writer io.Writer, data []DeployOutput) {
+	for i, out := range data {
+		if i > 0 {
+			fmt.Fprintln(writer)
+		}
+
+		fmt.Fprintf(writer, "%s deploy of %s:\n\n", out.Strategy, out.Target)
+
+		table := newTable(writer)
+		table.SetHeader([]string{"PHASE", "REQUESTS", "FAILURES"})
+
+		for _, phase := range out.Phases {
+			table.Append([]string{
+				phase.Name, strconv.Itoa(phase.Requests), strconv.Itoa(phase.Failures),
+			})
+		}
+
+		table.Render()
+
+		fmt.Fprintln(writer)
+
+		table = newTable(writer)
+		table.SetHeader([]string{"OLD", "TERMINATION", "SIGNAL", "EXIT CODE", "DURATION"})
+
+		for _, stopped := range out.Stopped {
+			table.Append([]string{
+				stopped.ShortID,
+				stopped.Termination.String(),
+				stopped.Signal,
+				strconv.Itoa(stopped.ExitCode),
+				stopped.StopDuration.Round(time.Millisecond).String(),
+			})
+		}
+
+		table.Render()
+
+		if failures := out.Failures(); failures > 0 {
+			fmt.Fprintf(writer, "\nDOWNTIME %s: %d requests failed\n", out.Target, failures)
+		} else {
+			fmt.Fprintf(writer, "\n%s: zero downtime\n", out.Target)
+		}
+
+		for _, reason := range out.Errors {
+			fmt.Fprintf(writer, "  %s\n", reason)
+		}
+	}
+}
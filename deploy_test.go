@@ -0,0 +1,60 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+)
+
+func TestReplacementConfig(t *testing.T) {
+	old := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			HostConfig: &container.HostConfig{
+				Binds:        []string{"data:/data"},
+				NetworkMode:  "backend",
+				PortBindings: nat.PortMap{"8080/tcp": {{HostPort: "8080"}}},
+				AutoRemove:   true,
+			},
+		},
+		Config: &container.Config{
+			Image:        "my-app:v1",
+			Entrypoint:   []string{"/old-entrypoint.sh"},
+			Cmd:          []string{"serve"},
+			User:         "old",
+			StopSignal:   "SIGQUIT",
+			WorkingDir:   "/old",
+			Labels:       map[string]string{"version": "v1"},
+			Env:          []string{"PATH=/usr/bin", "VERSION=v1", "DATABASE_URL=postgres://db"},
+			ExposedPorts: nat.PortSet{"8080/tcp": {}},
+		},
+	}
+
+	oldImage := &container.Config{Env: []string{"PATH=/usr/bin", "VERSION=v1"}}
+
+	config, hostConfig := replacementConfig(old, oldImage, "my-app:v2")
+
+	want := &container.Config{
+		Image:        "my-app:v2",
+		Env:          []string{"DATABASE_URL=postgres://db"},
+		ExposedPorts: nat.PortSet{"8080/tcp": {}},
+	}
+
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("replacementConfig() config = %+v, want %+v", config, want)
+	}
+
+	if hostConfig.PortBindings != nil || hostConfig.AutoRemove {
+		t.Errorf("replacementConfig() kept the port bindings or auto removal")
+	}
+
+	if !reflect.DeepEqual(hostConfig.Binds, []string{"data:/data"}) || hostConfig.NetworkMode != "backend" {
+		t.Errorf("replacementConfig() dropped the mounts or the network")
+	}
+
+	if old.HostConfig.PortBindings == nil {
+		t.Errorf("replacementConfig() changed the old container")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// dumpWait is how long we wait for a runtime to write its dump after receiving the
+// dump signal before collecting its logs
+const dumpWait = time.Second
+
+// Diagnostics is the evidence collected from a container that was about to be
+// force-killed, meant to show what the application was stuck on
+type Diagnostics struct {
+	// Elapsed is the time between the stop signal and the collection
+	Elapsed time.Duration
+
+	Processes []Process
+	Sockets   []Socket
+	Threads   int
+
+	// Dump is whatever the container logged after receiving the dump signal
+	Dump string
+
+	// Errors are the problems found while collecting, which may leave the rest of
+	// the fields incomplete
+	Errors []string
+}
+
+func collectDiagnostics(ctx context.Context, docker *client.Client, c string, dumpSignal string, elapsed time.Duration) *Diagnostics {
+	diag := &Diagnostics{Elapsed: elapsed}
+
+	json, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+		return diag
+	}
+
+	if dumpSignal != "" {
+		since := time.Now()
+
+		if err := docker.ContainerKill(ctx, c, dumpSignal); err != nil {
+			diag.Errors = append(diag.Errors, err.Error())
+		} else {
+			time.Sleep(dumpWait)
+
+			if diag.Dump, err = readLogs(ctx, docker, c, since); err != nil {
+				diag.Errors = append(diag.Errors, err.Error())
+			}
+		}
+	}
+
+	// prefer the host /proc, which works even on images that ship without a shell
+	if hasHostProc(json.State.Pid) {
+		diag.Processes, err = hostProcesses(ctx, docker, c)
+
+		if err != nil {
+			diag.Errors = append(diag.Errors, err.Error())
+		}
+
+		diag.Sockets, err = readSockets(json.State.Pid)
+
+		if err != nil {
+			diag.Errors = append(diag.Errors, err.Error())
+		}
+	} else {
+		diag.Processes, err = execProcesses(ctx, docker, c)
+
+		if err != nil {
+			diag.Errors = append(diag.Errors, err.Error())
+		}
+
+		diag.Sockets, err = execSockets(ctx, docker, c)
+
+		if err != nil {
+			diag.Errors = append(diag.Errors, err.Error())
+		}
+	}
+
+	for _, p := range diag.Processes {
+		diag.Threads += p.Threads
+	}
+
+	return diag
+}
+
+// hostProcesses lists the processes of a container through the daemon, then reads
+// the details of each of them from the host /proc
+func hostProcesses(ctx context.Context, docker *client.Client, c string) ([]Process, error) {
+	top, err := docker.ContainerTop(ctx, c, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	column := -1
+
+	for i, title := range top.Titles {
+		if title == "PID" {
+			column = i
+		}
+	}
+
+	if column < 0 {
+		return nil, fmt.Errorf("no PID column in process list of %s", c)
+	}
+
+	var processes []Process
+
+	for _, row := range top.Processes {
+		pid, err := strconv.Atoi(row[column])
+
+		if err != nil {
+			continue
+		}
+
+		// the process may have exited in the meantime
+		if p, err := readProcess(pid); err == nil {
+			processes = append(processes, p)
+		}
+	}
+
+	return processes, nil
+}
+
+// execProcesses lists the processes of a container by running ps inside it
+func execProcesses(ctx context.Context, docker *client.Client, c string) ([]Process, error) {
+	out, err := execInContainer(ctx, docker, c, []string{"ps", "-eo", "pid=,stat=,wchan:32=,nlwp=,args="})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var processes []Process
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) < 5 {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+
+		if err != nil {
+			continue
+		}
+
+		threads, _ := strconv.Atoi(fields[3])
+
+		processes = append(processes, Process{
+			PID:     pid,
+			State:   fields[1],
+			WChan:   fields[2],
+			Threads: threads,
+			Command: strings.Join(fields[4:], " "),
+		})
+	}
+
+	return processes, nil
+}
+
+// execSockets lists the TCP sockets of a container by reading /proc inside it
+func execSockets(ctx context.Context, docker *client.Client, c string) ([]Socket, error) {
+	out, err := execInContainer(ctx, docker, c, []string{"cat", "/proc/net/tcp", "/proc/net/tcp6"})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSockets(strings.NewReader(out)), nil
+}
+
+func writeDiagnostics(writer io.Writer, data []Output) {
+	for _, out := range data {
+		diag := out.Diagnostics
+
+		if diag == nil {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nDiagnostics for %s, collected %ds after the stop signal (%d threads):\n\n", out.ShortID, int(diag.Elapsed/time.Second), diag.Threads)
+
+		table := newTable(writer)
+		table.SetHeader([]string{"PID", "STATE", "WCHAN", "THREADS", "COMMAND"})
+
+		for _, p := range diag.Processes {
+			table.Append([]string{
+				strconv.Itoa(p.PID), p.State, p.WChan, strconv.Itoa(p.Threads), p.Command,
+			})
+		}
+
+		table.Render()
+
+		if len(diag.Sockets) > 0 {
+			fmt.Fprintln(writer)
+
+			table = newTable(writer)
+			table.SetHeader([]string{"LOCAL", "REMOTE", "STATE"})
+
+			for _, s := range diag.Sockets {
+				table.Append([]string{s.Local, s.Remote, s.State})
+			}
+
+			table.Render()
+		}
+
+		if diag.Dump != "" {
+			fmt.Fprintf(writer, "\n%s\n", strings.TrimRight(diag.Dump, "\n"))
+		}
+
+		for _, e := range diag.Errors {
+			fmt.Fprintf(writer, "\nerror: %s\n", e)
+		}
+	}
+}
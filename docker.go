@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// execInContainer runs a command inside a running container and returns its
+// standard output. An error is returned if the command exits with a non-zero code.
+func execInContainer(ctx context.Context, docker *client.Client, c string, cmd []string) (string, error) {
+	return execWithInput(ctx, docker, c, cmd, nil, nil)
+}
+
+// execWithInput is like execInContainer, but also sets environment variables and
+// writes to the standard input of the command, if given
+func execWithInput(ctx context.Context, docker *client.Client, c string, cmd []string, env []string, stdin []byte) (string, error) {
+	exec, err := docker.ContainerExecCreate(ctx, c, types.ExecConfig{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          env,
+		Cmd:          cmd,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := docker.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{})
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Close()
+
+	if stdin != nil {
+		if _, err := resp.Conn.Write(stdin); err != nil {
+			return "", err
+		}
+
+		if err := resp.CloseWrite(); err != nil {
+			return "", err
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, resp.Reader); err != nil {
+		return "", err
+	}
+
+	inspect, err := docker.ContainerExecInspect(ctx, exec.ID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if inspect.ExitCode != 0 {
+		return stdout.String(), fmt.Errorf("command %q exited with code %d: %s", cmd, inspect.ExitCode, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return stdout.String(), nil
+}
+
+// runToCompletion runs a helper container until it exits, within the given timeout,
+// and returns its logs and exit code. The container is removed afterwards.
+func runToCompletion(ctx context.Context, docker *client.Client, config *container.Config, hostConfig *container.HostConfig, timeout time.Duration) (string, int64, error) {
+	created, err := docker.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer func() {
+		_ = docker.ContainerRemove(ctx, created.ID, types.ContainerRemoveOptions{Force: true})
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// wait before starting, so a quick exit is not missed
+	exited, failed := docker.ContainerWait(waitCtx, created.ID, container.WaitConditionNextExit)
+
+	if err := docker.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		return "", 0, err
+	}
+
+	var code int64
+
+	select {
+	case result := <-exited:
+		code = result.StatusCode
+	case err := <-failed:
+		return "", 0, err
+	}
+
+	logs, err := readLogs(ctx, docker, created.ID, time.Time{})
+
+	return logs, code, err
+}
+
+// readLogs returns the standard output and standard error a container logged since
+// the given time, or since it was created if the time is zero, interleaved
+func readLogs(ctx context.Context, docker *client.Client, c string, since time.Time) (string, error) {
+	json, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return "", err
+	}
+
+	options := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	}
+
+	if !since.IsZero() {
+		options.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+
+	logs, err := docker.ContainerLogs(ctx, c, options)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer logs.Close()
+
+	var buf bytes.Buffer
+
+	// logs are only multiplexed when the container has no TTY
+	if json.Config.Tty {
+		_, err = io.Copy(&buf, logs)
+	} else {
+		_, err = stdcopy.StdCopy(&buf, &buf, logs)
+	}
+
+	return buf.String(), err
+}
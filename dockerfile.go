@@ -0,0 +1,641 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// knownServers maps servers that shut down gracefully on a signal other than
+// SIGTERM to that signal, and to their official image, which already sets it
+var knownServers = map[string]struct {
+	Signal string
+	Image  string
+}{
+	"nginx":    {"SIGQUIT", "nginx"},
+	"httpd":    {"SIGWINCH", "httpd"},
+	"apache2":  {"SIGWINCH", "httpd"},
+	"php-fpm":  {"SIGQUIT", "php"},
+	"haproxy":  {"SIGUSR1", "haproxy"},
+	"postgres": {"SIGINT", "postgres"},
+}
+
+// Instruction is a single instruction of a Dockerfile, which may span several lines
+type Instruction struct {
+	// Line and EndLine are the first and last lines of the instruction, from 1
+	Line    int
+	EndLine int
+
+	Command string
+	Args    string
+}
+
+// Stage is a build stage of a Dockerfile, starting with a FROM instruction
+type Stage struct {
+	Base         string
+	Name         string
+	Instructions []Instruction
+}
+
+// heredocMarker matches the heredocs of an instruction, such as <<EOF, <<-EOF or
+// <<"EOF", but not here-strings such as <<<word
+var heredocMarker = regexp.MustCompile(`(?:^|[^<])<<(-?)(?:"(\w+)"|'(\w+)'|(\w+))`)
+
+// heredoc is a heredoc whose content is still being read
+type heredoc struct {
+	delimiter string
+
+	// stripTabs is set for <<-, which allows indenting the delimiter with tabs
+	stripTabs bool
+}
+
+// heredocs returns the heredocs an instruction starts, in order. Only RUN, COPY and
+// ADD support them.
+func heredocs(text string) []heredoc {
+	switch strings.ToUpper(strings.SplitN(strings.TrimSpace(text), " ", 2)[0]) {
+	case "RUN", "COPY", "ADD":
+	default:
+		return nil
+	}
+
+	var docs []heredoc
+
+	for _, m := range heredocMarker.FindAllStringSubmatch(text, -1) {
+		docs = append(docs, heredoc{delimiter: m[2] + m[3] + m[4], stripTabs: m[1] == "-"})
+	}
+
+	return docs
+}
+
+// parseDockerfile splits a Dockerfile into its stages. It only understands what is
+// needed to lint it: comments, line continuations, heredocs and the escape
+// directive.
+func parseDockerfile(r io.Reader) ([]Stage, error) {
+	var stages []Stage
+
+	escape := `\`
+	directives := true
+
+	scanner := bufio.NewScanner(r)
+
+	var current *Instruction
+	var text strings.Builder
+	n := 0
+
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+
+		fields := strings.SplitN(strings.TrimSpace(text.String()), " ", 2)
+		current.Command = strings.ToUpper(fields[0])
+
+		if len(fields) == 2 {
+			current.Args = strings.TrimSpace(fields[1])
+		}
+
+		if current.Command == "FROM" {
+			stage := Stage{}
+			args := strings.Fields(current.Args)
+
+			// skip flags such as --platform
+			for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+				args = args[1:]
+			}
+
+			if len(args) == 0 {
+				return fmt.Errorf("line %d: FROM has no image", current.Line)
+			}
+
+			stage.Base = args[0]
+
+			if len(args) == 3 && strings.EqualFold(args[1], "AS") {
+				stage.Name = args[2]
+			}
+
+			stages = append(stages, stage)
+		}
+
+		if len(stages) == 0 {
+			if current.Command != "ARG" {
+				return fmt.Errorf("line %d: %s before FROM", current.Line, current.Command)
+			}
+		} else {
+			stages[len(stages)-1].Instructions = append(stages[len(stages)-1].Instructions, *current)
+		}
+
+		current = nil
+		text.Reset()
+
+		return nil
+	}
+
+	// pending are the heredocs of the current instruction left to read
+	var pending []heredoc
+
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		// heredocs are part of the instruction, whatever their lines look like
+		if len(pending) > 0 {
+			current.EndLine = n
+			text.WriteString("\n" + line)
+
+			end := line
+
+			if pending[0].stripTabs {
+				end = strings.TrimLeft(end, "\t")
+			}
+
+			if end == pending[0].delimiter {
+				pending = pending[1:]
+			}
+
+			if len(pending) == 0 {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "#") {
+			// parser directives are only allowed at the very top
+			if directives {
+				if d := strings.TrimSpace(strings.TrimPrefix(trimmed, "#")); strings.HasPrefix(strings.ToLower(d), "escape=") {
+					escape = strings.TrimSpace(d[len("escape="):])
+				}
+			}
+
+			continue
+		}
+
+		directives = false
+
+		if trimmed == "" && current == nil {
+			continue
+		}
+
+		if current == nil {
+			current = &Instruction{Line: n}
+		}
+
+		current.EndLine = n
+
+		if strings.HasSuffix(trimmed, escape) {
+			text.WriteString(strings.TrimSuffix(trimmed, escape) + " ")
+			continue
+		}
+
+		text.WriteString(trimmed)
+
+		if pending = heredocs(text.String()); len(pending) > 0 {
+			continue
+		}
+
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("line %d: heredoc %s is not terminated", current.Line, pending[0].delimiter)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("no FROM instruction found")
+	}
+
+	return stages, nil
+}
+
+// execForm parses the arguments of an instruction in exec form, such as
+// ["./app", "--port", "80"], and tells if they are in that form
+func execForm(args string) ([]string, bool) {
+	var command []string
+
+	if !strings.HasPrefix(args, "[") {
+		return nil, false
+	}
+
+	if err := json.Unmarshal([]byte(args), &command); err != nil {
+		return nil, false
+	}
+
+	return command, true
+}
+
+// shellCommand returns how Docker runs an ENTRYPOINT or CMD instruction
+func shellCommand(args string) []string {
+	if command, ok := execForm(args); ok {
+		return command
+	}
+
+	return []string{"/bin/sh", "-c", args}
+}
+
+// lintDockerfile checks every stage for shell form commands, and the final stage,
+// along with the stages it is based on, for a stop signal and an init process
+func lintDockerfile(file string, stages []Stage) []Finding {
+	var findings []Finding
+
+	add := func(line int, severity, rule, format string, args ...interface{}) {
+		findings = append(findings, Finding{
+			Target:   file + ":" + strconv.Itoa(line),
+			Severity: severity,
+			Rule:     rule,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	for si, stage := range stages {
+		for ii, inst := range stage.Instructions {
+			if (inst.Command != "ENTRYPOINT" && inst.Command != "CMD") || !isShellForm(shellCommand(inst.Args)) {
+				continue
+			}
+
+			if inst.Command == "ENTRYPOINT" && cmdFollows(stages, si, ii) {
+				add(inst.Line, SeverityError, RuleShellForm, "ENTRYPOINT is in shell form, so the shell is PID 1 and does not forward the stop signal; use the exec form, but mind that the CMD is then appended to it")
+				continue
+			}
+
+			add(inst.Line, SeverityError, RuleShellForm, "%s is in shell form, so the shell is PID 1 and does not forward the stop signal; use the exec form", inst.Command)
+		}
+	}
+
+	// the final stage inherits from the stages it is based on
+	var entrypoint, cmd, stopSignal *Instruction
+
+	final := stages[len(stages)-1]
+	chain := []Stage{final}
+
+	for base := final.Base; ; {
+		found := false
+
+		for _, stage := range stages {
+			if stage.Name != "" && stage.Name == base {
+				chain = append([]Stage{stage}, chain...)
+				base = stage.Base
+				found = true
+
+				break
+			}
+		}
+
+		if !found || len(chain) > len(stages) {
+			break
+		}
+	}
+
+	for _, stage := range chain {
+		cmdSet := false
+
+		for i, inst := range stage.Instructions {
+			switch inst.Command {
+			case "ENTRYPOINT":
+				entrypoint = &stage.Instructions[i]
+
+				// a new entrypoint resets the command of the base image
+				if !cmdSet {
+					cmd = nil
+				}
+			case "CMD":
+				cmd, cmdSet = &stage.Instructions[i], true
+			case "STOPSIGNAL":
+				stopSignal = &stage.Instructions[i]
+			}
+		}
+	}
+
+	last := chain[len(chain)-1].Instructions[0]
+	base := chain[0].Base
+
+	var command []string
+
+	if entrypoint != nil {
+		command = shellCommand(entrypoint.Args)
+		last = *entrypoint
+	} else if cmd != nil {
+		command = shellCommand(cmd.Args)
+		last = *cmd
+	}
+
+	// the command may well be inherited from the base image, which we can't see
+	if len(command) == 0 {
+		return findings
+	}
+
+	// the words of the command, as the shell would run them if there is one
+	words := command
+
+	if len(command) == 3 && command[1] == "-c" {
+		words = strings.Fields(command[2])
+
+		if len(words) > 0 && words[0] == "exec" {
+			words = words[1:]
+		}
+	}
+
+	// the command is passed to an exec form entrypoint
+	if entrypoint != nil && cmd != nil {
+		if _, ok := execForm(entrypoint.Args); ok {
+			words = append(words, shellCommand(cmd.Args)...)
+		}
+	}
+
+	if len(words) > 0 && !isShellForm(command) && !isInit(words[0]) {
+		add(last.Line, SeverityWarning, RuleNoInit, "%s runs %s rather than an init process, so it must handle signals and reap zombies itself; consider tini or dumb-init", last.Command, path.Base(words[0]))
+	}
+
+	program := ""
+
+	for _, word := range words {
+		if _, ok := knownServers[path.Base(word)]; ok {
+			program = path.Base(word)
+			break
+		}
+	}
+
+	if server, ok := knownServers[program]; ok && stopSignal == nil && imageRepository(base) != server.Image {
+		add(last.Line, SeverityWarning, RuleStopSignal, "%s shuts down gracefully on %s, but no STOPSIGNAL is set; add STOPSIGNAL %s", program, server.Signal, server.Signal)
+	}
+
+	return findings
+}
+
+// cmdFollows tells if a CMD applies along with an ENTRYPOINT instruction: one set in
+// its stage, or in a stage based on it. Only the CMD of the base image is reset by
+// the entrypoint.
+func cmdFollows(stages []Stage, si, ii int) bool {
+	for i, inst := range stages[si].Instructions {
+		if i != ii && inst.Command == "CMD" {
+			return true
+		}
+	}
+
+	name := stages[si].Name
+
+	if name == "" {
+		return false
+	}
+
+	for i := si + 1; i < len(stages); i++ {
+		if stages[i].Base == name && cmdFollows(stages, i, -1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fixDockerfile rewrites shell form ENTRYPOINT and CMD instructions into exec form,
+// when they are simple enough to do so without changing their meaning. It returns
+// the fixed lines and a unified diff.
+func fixDockerfile(file string, lines []string, stages []Stage) ([]string, string) {
+	var fixed []string
+	var diff bytes.Buffer
+
+	next := 0
+	offset := 0
+
+	for si, stage := range stages {
+		for ii, inst := range stage.Instructions {
+			if inst.Command != "ENTRYPOINT" && inst.Command != "CMD" || !isShellForm(shellCommand(inst.Args)) {
+				continue
+			}
+
+			// a shell form entrypoint ignores the command, which an exec form one
+			// would be given as arguments
+			if inst.Command == "ENTRYPOINT" && cmdFollows(stages, si, ii) {
+				continue
+			}
+
+			words, ok := shellWords(inst.Args)
+
+			if !ok || len(words) == 0 {
+				continue
+			}
+
+			// keep the original spelling and indentation of the instruction
+			first := lines[inst.Line-1]
+			indent := first[:len(first)-len(strings.TrimLeft(first, " \t"))]
+			keyword := strings.Fields(first)[0]
+
+			replacement := indent + keyword + " " + execFormString(words)
+
+			// lines are split on \n, so those of a CRLF file keep their \r
+			if strings.HasSuffix(lines[inst.EndLine-1], "\r") {
+				replacement += "\r"
+			}
+
+			fixed = append(fixed, lines[next:inst.Line-1]...)
+			fixed = append(fixed, replacement)
+
+			count := inst.EndLine - inst.Line + 1
+
+			fmt.Fprintf(&diff, "@@ -%d,%d +%d,1 @@\n", inst.Line, count, inst.Line+offset)
+
+			for _, line := range lines[inst.Line-1 : inst.EndLine] {
+				fmt.Fprintf(&diff, "-%s\n", line)
+			}
+
+			fmt.Fprintf(&diff, "+%s\n", replacement)
+
+			offset += 1 - count
+			next = inst.EndLine
+		}
+	}
+
+	fixed = append(fixed, lines[next:]...)
+
+	if diff.Len() == 0 {
+		return fixed, ""
+	}
+
+	name := strings.TrimPrefix(filepath.ToSlash(file), "/")
+
+	return fixed, fmt.Sprintf("--- a/%s\n+++ b/%s\n%s", name, name, diff.String())
+}
+
+// shellWords splits a shell command into words, honoring quotes, and tells if it
+// could do so without a shell: it fails on anything a shell would expand or
+// interpret, such as variables, globs, pipes or redirections
+func shellWords(s string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+
+	inWord := false
+	quote := rune(0)
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '$', '`', '\\':
+				return nil, false
+			default:
+				word.WriteRune(r)
+			}
+
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+
+		case r == '\\':
+			escaped = true
+			inWord = true
+
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case strings.ContainsRune("|&;<>()$`*?[]{}", r), !inWord && (r == '~' || r == '#'):
+			return nil, false
+
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, false
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	// a leading assignment sets an environment variable for the command
+	if len(words) > 0 && strings.Contains(words[0], "=") {
+		return nil, false
+	}
+
+	return words, true
+}
+
+// jsonString quotes a string for an exec form instruction, without escaping HTML
+// characters like json.Marshal does
+func jsonString(s string) string {
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// execFormString writes a command in exec form, as a JSON array
+func execFormString(words []string) string {
+	var quoted []string
+
+	for _, word := range words {
+		quoted = append(quoted, jsonString(word))
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+var lintDockerfileCommand = &cli.Command{
+	Name:      "lint-dockerfile",
+	Usage:     "checks Dockerfiles for termination problems, and optionally fixes them",
+	UsageText: "grace lint-dockerfile [--fix] DOCKERFILE [DOCKERFILE ...]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "fix",
+			Usage: "rewrite shell form ENTRYPOINT and CMD instructions into exec form, and print the diff",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			cli.ShowCommandHelpAndExit(c, "lint-dockerfile", 0)
+		}
+
+		return runLintDockerfile(c.Args().Slice(), c.Bool("fix"), os.Stdout)
+	},
+}
+
+func runLintDockerfile(files []string, fix bool, writer io.Writer) error {
+	var findings []Finding
+	var diffs []string
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+
+		if err != nil {
+			return err
+		}
+
+		stages, err := parseDockerfile(bytes.NewReader(data))
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		if fix {
+			lines := strings.Split(string(data), "\n")
+
+			if fixed, diff := fixDockerfile(file, lines, stages); diff != "" {
+				if err := os.WriteFile(file, []byte(strings.Join(fixed, "\n")), 0644); err != nil {
+					return err
+				}
+
+				diffs = append(diffs, diff)
+
+				// what is left to report is what could not be fixed
+				if stages, err = parseDockerfile(strings.NewReader(strings.Join(fixed, "\n"))); err != nil {
+					return fmt.Errorf("%s: %w", file, err)
+				}
+			}
+		}
+
+		findings = append(findings, lintDockerfile(file, stages)...)
+	}
+
+	for _, diff := range diffs {
+		fmt.Fprintln(writer, diff)
+	}
+
+	writeFindings(writer, findings)
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []Stage
+		wantErr bool
+	}{
+		{
+			name: "stages",
+			in: `ARG VERSION=1
+FROM --platform=linux/amd64 golang:1.17 AS build
+# a comment
+run go build \
+    -o /app .
+
+FROM alpine
+COPY --from=build /app /app
+ENTRYPOINT ["/app"]
+`,
+			want: []Stage{
+				{Base: "golang:1.17", Name: "build", Instructions: []Instruction{
+					{Line: 2, EndLine: 2, Command: "FROM", Args: "--platform=linux/amd64 golang:1.17 AS build"},
+					{Line: 4, EndLine: 5, Command: "RUN", Args: "go build  -o /app ."},
+				}},
+				{Base: "alpine", Instructions: []Instruction{
+					{Line: 7, EndLine: 7, Command: "FROM", Args: "alpine"},
+					{Line: 8, EndLine: 8, Command: "COPY", Args: "--from=build /app /app"},
+					{Line: 9, EndLine: 9, Command: "ENTRYPOINT", Args: `["/app"]`},
+				}},
+			},
+		},
+		{
+			name: "escape directive",
+			in:   "# escape=`\nFROM alpine\nCMD echo `\n  hello\n",
+			want: []Stage{
+				{Base: "alpine", Instructions: []Instruction{
+					{Line: 2, EndLine: 2, Command: "FROM", Args: "alpine"},
+					{Line: 3, EndLine: 4, Command: "CMD", Args: "echo  hello"},
+				}},
+			},
+		},
+		{
+			name: "heredocs",
+			in: `FROM alpine
+RUN <<EOF
+# not a comment
+FROM not an instruction
+EOF
+COPY <<-"CONF" /etc/app.conf
+	debug = true
+	CONF
+RUN cat <<A && cat <<'B'
+a
+A
+b
+B
+RUN cat <<<word
+CMD ["/app"]
+`,
+			want: []Stage{
+				{Base: "alpine", Instructions: []Instruction{
+					{Line: 1, EndLine: 1, Command: "FROM", Args: "alpine"},
+					{Line: 2, EndLine: 5, Command: "RUN", Args: "<<EOF\n# not a comment\nFROM not an instruction\nEOF"},
+					{Line: 6, EndLine: 8, Command: "COPY", Args: "<<-\"CONF\" /etc/app.conf\n\tdebug = true\n\tCONF"},
+					{Line: 9, EndLine: 13, Command: "RUN", Args: "cat <<A && cat <<'B'\na\nA\nb\nB"},
+					{Line: 14, EndLine: 14, Command: "RUN", Args: "cat <<<word"},
+					{Line: 15, EndLine: 15, Command: "CMD", Args: `["/app"]`},
+				}},
+			},
+		},
+		{
+			name: "heredoc only in RUN, COPY and ADD",
+			in:   "FROM alpine\nCMD cat <<EOF\n",
+			want: []Stage{
+				{Base: "alpine", Instructions: []Instruction{
+					{Line: 1, EndLine: 1, Command: "FROM", Args: "alpine"},
+					{Line: 2, EndLine: 2, Command: "CMD", Args: "cat <<EOF"},
+				}},
+			},
+		},
+		{name: "unterminated heredoc", in: "FROM alpine\nRUN <<EOF\necho\n", wantErr: true},
+		{name: "instruction before FROM", in: "RUN echo\nFROM alpine\n", wantErr: true},
+		{name: "FROM without image", in: "FROM --platform=linux/amd64\n", wantErr: true},
+		{name: "no FROM", in: "# empty\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDockerfile(strings.NewReader(tt.in))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDockerfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDockerfile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixDockerfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		want     string
+		wantDiff string
+	}{
+		{
+			name: "shell form",
+			in:   "FROM alpine\n  cmd ./app \\\n    --port 80\n",
+			want: "FROM alpine\n  cmd [\"./app\", \"--port\", \"80\"]\n",
+			wantDiff: `--- a/Dockerfile
++++ b/Dockerfile
+@@ -2,2 +2,1 @@
+-  cmd ./app \
+-    --port 80
++  cmd ["./app", "--port", "80"]
+`,
+		},
+		{
+			name:     "CRLF line endings",
+			in:       "FROM alpine\r\nCMD ./app \\\r\n  --port 80\r\nUSER app\r\n",
+			want:     "FROM alpine\r\nCMD [\"./app\", \"--port\", \"80\"]\r\nUSER app\r\n",
+			wantDiff: "--- a/Dockerfile\n+++ b/Dockerfile\n@@ -2,2 +2,1 @@\n-CMD ./app \\\r\n-  --port 80\r\n+CMD [\"./app\", \"--port\", \"80\"]\r\n",
+		},
+		{
+			name: "needs a shell",
+			in:   "FROM alpine\nCMD ./app --port $PORT\n",
+			want: "FROM alpine\nCMD ./app --port $PORT\n",
+		},
+		{
+			name: "entrypoint before its command",
+			in:   "FROM alpine\nENTRYPOINT ./app\nCMD [\"--port\", \"80\"]\n",
+			want: "FROM alpine\nENTRYPOINT ./app\nCMD [\"--port\", \"80\"]\n",
+		},
+		{
+			name: "entrypoint with a command in a later stage",
+			in:   "FROM alpine AS base\nENTRYPOINT ./app\nFROM base\nCMD [\"--debug\"]\n",
+			want: "FROM alpine AS base\nENTRYPOINT ./app\nFROM base\nCMD [\"--debug\"]\n",
+		},
+		{
+			name: "entrypoint after its command",
+			in:   "FROM alpine\nCMD [\"--debug\"]\nENTRYPOINT ./app\n",
+			want: "FROM alpine\nCMD [\"--debug\"]\nENTRYPOINT ./app\n",
+		},
+		{
+			name: "entrypoint resetting the command of the base image",
+			in:   "FROM alpine AS base\nCMD [\"--debug\"]\nFROM base\nENTRYPOINT ./app\n",
+			want: "FROM alpine AS base\nCMD [\"--debug\"]\nFROM base\nENTRYPOINT [\"./app\"]\n",
+			wantDiff: `--- a/Dockerfile
++++ b/Dockerfile
+@@ -4,1 +4,1 @@
+-ENTRYPOINT ./app
++ENTRYPOINT ["./app"]
+`,
+		},
+		{
+			name: "after a heredoc",
+			in:   "FROM alpine\nRUN <<EOF\nCMD not an instruction\nEOF\nCMD ./app\n",
+			want: "FROM alpine\nRUN <<EOF\nCMD not an instruction\nEOF\nCMD [\"./app\"]\n",
+			wantDiff: `--- a/Dockerfile
++++ b/Dockerfile
+@@ -5,1 +5,1 @@
+-CMD ./app
++CMD ["./app"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stages, err := parseDockerfile(strings.NewReader(tt.in))
+
+			if err != nil {
+				t.Fatalf("parseDockerfile() error = %v", err)
+			}
+
+			fixed, diff := fixDockerfile("Dockerfile", strings.Split(tt.in, "\n"), stages)
+
+			if got := strings.Join(fixed, "\n"); got != tt.want {
+				t.Errorf("fixDockerfile() = %q, want %q", got, tt.want)
+			}
+
+			if diff != tt.wantDiff {
+				t.Errorf("fixDockerfile() diff = %q, want %q", diff, tt.wantDiff)
+			}
+		})
+	}
+}
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   []string
+		wantOK bool
+	}{
+		{in: "./app --port 80", want: []string{"./app", "--port", "80"}, wantOK: true},
+		{in: `  python  "my app.py"  'a b' c\ d`, want: []string{"python", "my app.py", "a b", "c d"}, wantOK: true},
+		{in: `echo "it's" ''`, want: []string{"echo", "it's", ""}, wantOK: true},
+		{in: "app a=b a#b", want: []string{"app", "a=b", "a#b"}, wantOK: true},
+		{in: "", wantOK: true},
+		{in: "./app $PORT"},
+		{in: `echo "$HOME"`},
+		{in: "a | b"},
+		{in: "a && b"},
+		{in: "a > log"},
+		{in: "ls *.go"},
+		{in: "cd ~"},
+		{in: "app # comment"},
+		{in: "PORT=80 ./app"},
+		{in: `echo "unterminated`},
+		{in: `echo trailing\`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := shellWords(tt.in)
+
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shellWords(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLintDockerfileEntrypointWithCommand(t *testing.T) {
+	stages, err := parseDockerfile(strings.NewReader("FROM alpine\nENTRYPOINT ./app\nCMD [\"--debug\"]\n"))
+
+	if err != nil {
+		t.Fatalf("parseDockerfile() error = %v", err)
+	}
+
+	var found bool
+
+	for _, f := range lintDockerfile("Dockerfile", stages) {
+		if f.Rule == RuleShellForm && f.Target == "Dockerfile:2" {
+			found = strings.Contains(f.Message, "CMD is then appended")
+		}
+	}
+
+	if !found {
+		t.Errorf("lintDockerfile() did not warn that the CMD would be appended to the entrypoint")
+	}
+}
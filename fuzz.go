@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	phaseStarting = "starting"
+	phaseReady    = "ready"
+)
+
+// errExited tells that a fuzzed container exited on its own before the signal
+var errExited = errors.New("exited before the stop signal")
+
+// Fuzz holds the options of the fuzz command
+type Fuzz struct {
+	Runs    int
+	Buckets int
+	Seed    int64
+
+	// Window is the range of delays after the container start at which the stop
+	// signal is sent, zero means twice the time the container takes to be ready
+	Window time.Duration
+}
+
+// FuzzRange aggregates the fuzzing runs of a target whose stop signal was sent
+// within the same range of delays after the container start
+type FuzzRange struct {
+	Target string
+
+	From time.Duration
+	To   time.Duration
+
+	// Phase tells if the range is before or after the container is ready, or both
+	Phase string
+
+	Counts map[Termination]int
+
+	// Exited counts the containers that exited on their own before the signal,
+	// which are failed runs as well
+	Exited int
+
+	// Stops are the results of the runs within the range
+	Stops []Output
+
+	// Missed counts the runs planned within the range whose signal was sent in a
+	// later one, because creating and preparing the container took longer
+	Missed int
+}
+
+// Verdict summarizes the terminations of a range as "ok", "hang", "crash" or
+// "hang+crash"
+func (r FuzzRange) Verdict() string {
+	var hang, crash bool
+
+	for t := range r.Counts {
+		switch t {
+		case ForceKilled, SignalIgnored:
+			hang = true
+		case GracefulError, OOMKilled, Unhandled, Unverified:
+			crash = true
+		}
+	}
+
+	crash = crash || r.Exited > 0
+
+	switch {
+	case len(r.Counts) == 0 && r.Exited == 0:
+		return "-"
+	case hang && crash:
+		return "hang+crash"
+	case hang:
+		return "hang"
+	case crash:
+		return "crash"
+	}
+
+	return "ok"
+}
+
+var fuzzFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  "runs",
+		Usage: "stop `N` fresh containers of each target",
+		Value: 20,
+	},
+	&cli.DurationFlag{
+		Name:  "window",
+		Usage: "send the stop signal at random delays up to this `DURATION` after the start (default: twice the time to readiness)",
+	},
+	&cli.IntFlag{
+		Name:  "buckets",
+		Usage: "report results in `N` ranges of delays",
+		Value: 5,
+	},
+	&cli.Int64Flag{
+		Name:  "seed",
+		Usage: "`SEED` of the random delays, to reproduce a previous run (default: random)",
+	},
+}
+
+var fuzzCommand = &cli.Command{
+	Name:      "fuzz",
+	Usage:     "sends the stop signal at random points during the startup of fresh containers",
+	UsageText: "grace fuzz --clone|--image [OPTIONS] [CONTAINER|IMAGE [CONTAINER|IMAGE ...]]",
+	Flags:     commandFlags(fuzzFlags, runFlags),
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			cli.ShowCommandHelpAndExit(c, "fuzz", 0)
+		}
+
+		in, err := parseInput(c)
+
+		if err != nil {
+			return err
+		}
+
+		if !in.Mode.fresh() {
+			return fmt.Errorf("fuzz requires either clone or image")
+		}
+
+		fuzz := Fuzz{
+			Runs:    c.Int("runs"),
+			Buckets: c.Int("buckets"),
+			Seed:    c.Int64("seed"),
+			Window:  c.Duration("window"),
+		}
+
+		if !c.IsSet("seed") {
+			fuzz.Seed = time.Now().UnixNano()
+		}
+
+		if fuzz.Runs < 1 || fuzz.Buckets < 1 {
+			return fmt.Errorf("fuzz needs at least one run and one bucket")
+		}
+
+		return runFuzz(in, fuzz, os.Stdout)
+	},
+}
+
+func runFuzz(in Input, fuzz Fuzz, writer io.Writer) error {
+	var data []FuzzRange
+
+	rng := rand.New(rand.NewSource(fuzz.Seed))
+
+	for _, target := range in.Containers {
+		ranges, err := fuzzTarget(context.Background(), in, fuzz, target, rng)
+
+		if err != nil {
+			return err
+		}
+
+		data = append(data, ranges...)
+	}
+
+	writeFuzz(writer, fuzz, data)
+
+	var all []Output
+
+	for _, r := range data {
+		all = append(all, r.Stops...)
+	}
+
+	return afterReport(context.Background(), in, all)
+}
+
+func fuzzTarget(ctx context.Context, in Input, fuzz Fuzz, target string, rng *rand.Rand) ([]FuzzRange, error) {
+	ready, err := measureReadiness(ctx, in, target)
+
+	if err != nil {
+		return nil, err
+	}
+
+	window := fuzz.Window
+
+	if window == 0 {
+		window = 2 * ready
+	}
+
+	if window < time.Second {
+		window = time.Second
+	}
+
+	ranges := make([]FuzzRange, fuzz.Buckets)
+	width := window / time.Duration(fuzz.Buckets)
+
+	for i := range ranges {
+		ranges[i] = FuzzRange{
+			Target: target,
+			From:   time.Duration(i) * width,
+			To:     time.Duration(i+1) * width,
+			Counts: map[Termination]int{},
+		}
+	}
+
+	for i := 0; i < fuzz.Runs; i++ {
+		delay := time.Duration(rng.Int63n(int64(width) * int64(fuzz.Buckets)))
+		planned := fuzzBucket(delay, width, fuzz.Buckets)
+
+		out, offset, err := fuzzOnce(ctx, in, target, delay)
+
+		// containers exit on their own before the planned delay
+		if errors.Is(err, errExited) {
+			ranges[planned].Exited++
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// runs are grouped by when the signal was really sent
+		actual := fuzzBucket(offset, width, fuzz.Buckets)
+
+		if actual != planned {
+			ranges[planned].Missed++
+		}
+
+		r := &ranges[actual]
+		r.Counts[out.Termination]++
+		r.Stops = append(r.Stops, out)
+
+		if offset >= r.To {
+			r.To = offset
+		}
+	}
+
+	for i := range ranges {
+		ranges[i].Phase = fuzzPhase(ranges[i].From, ranges[i].To, ready)
+	}
+
+	return ranges, nil
+}
+
+// fuzzBucket is the range of delays a delay falls in, where the last range holds
+// the delays past the window as well
+func fuzzBucket(delay, width time.Duration, buckets int) int {
+	if i := int(delay / width); i < buckets {
+		return i
+	}
+
+	return buckets - 1
+}
+
+// fuzzPhase tells if a range of delays is before or after the container is ready,
+// or both
+func fuzzPhase(from, to, ready time.Duration) string {
+	switch {
+	case to <= ready:
+		return phaseStarting
+	case from >= ready:
+		return phaseReady
+	}
+
+	return phaseStarting + "/" + phaseReady
+}
+
+// measureReadiness starts a fresh container and measures how long it takes to be
+// ready, which is immediate for containers we can't tell about
+func measureReadiness(ctx context.Context, in Input, target string) (time.Duration, error) {
+	c, err := create(ctx, in, target)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer cleanup(ctx, in, c)
+
+	return waitReady(ctx, in, c)
+}
+
+// fuzzOnce starts a fresh container and stops it after the given delay, whether it
+// is ready or not, and tells how long after the start the signal was really sent
+func fuzzOnce(ctx context.Context, in Input, target string, delay time.Duration) (Output, time.Duration, error) {
+	// burners are started first, so they don't delay the stop signal
+	burners, err := startBurners(ctx, in)
+
+	if err != nil {
+		return Output{}, 0, err
+	}
+
+	defer stopBurners(ctx, in, burners)
+
+	c, err := create(ctx, in, target)
+
+	if err != nil {
+		return Output{}, 0, err
+	}
+
+	defer cleanup(ctx, in, c)
+
+	// a container exiting before the signal fails the run, not the whole fuzzing
+	exited := func(err error) error {
+		if json, ierr := in.Docker.ContainerInspect(ctx, c); ierr == nil && !json.State.Running {
+			return errExited
+		}
+
+		return err
+	}
+
+	// everything that comes before the signal is done first, so that it does not
+	// delay it
+	prepared, err := prepareStop(ctx, in, target, c)
+
+	if err != nil {
+		return Output{}, 0, exited(err)
+	}
+
+	started, err := time.Parse(time.RFC3339Nano, prepared.json.State.StartedAt)
+
+	if err != nil {
+		return Output{}, 0, err
+	}
+
+	time.Sleep(time.Until(started.Add(delay)))
+
+	out, err := stopPrepared(ctx, in, target, prepared)
+
+	if err != nil {
+		return Output{}, 0, exited(err)
+	}
+
+	return out, out.Signaled.Sub(started), nil
+}
+
+func writeFuzz(writer io.Writer, fuzz Fuzz, data []FuzzRange) {
+	table := newTable(writer)
+
+	var rows [][]string
+
+	for _, r := range data {
+		runs := r.Exited
+
+		for _, n := range r.Counts {
+			runs += n
+		}
+
+		terminations := formatCounts(r.Counts)
+
+		if r.Exited > 0 {
+			terminations = strings.TrimSpace(fmt.Sprintf("%s Exited=%d", terminations, r.Exited))
+		}
+
+		rows = append(rows, []string{
+			r.Target,
+			fmt.Sprintf("%s-%s", r.From.Round(time.Millisecond), r.To.Round(time.Millisecond)),
+			r.Phase,
+			strconv.Itoa(runs),
+			terminations,
+			r.Verdict(),
+		})
+	}
+
+	table.SetHeader([]string{
+		"TARGET", "DELAY", "PHASE", "RUNS", "TERMINATIONS", "VERDICT",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, r := range data {
+		if verdict := r.Verdict(); verdict != "ok" && verdict != "-" {
+			fmt.Fprintf(writer, "\n%s: %s when stopped %s to %s after the start (%s)", r.Target, verdict, r.From.Round(time.Millisecond), r.To.Round(time.Millisecond), r.Phase)
+		}
+	}
+
+	for _, r := range data {
+		if r.Missed > 0 {
+			fmt.Fprintf(writer, "\n%s: %d runs planned %s to %s after the start were signaled later, since creating and preparing the container took longer", r.Target, r.Missed, r.From.Round(time.Millisecond), r.To.Round(time.Millisecond))
+		}
+	}
+
+	fmt.Fprintf(writer, "\nreproduce with --seed %d\n", fuzz.Seed)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFuzzRangeVerdict(t *testing.T) {
+	tests := []struct {
+		name string
+		r    FuzzRange
+		want string
+	}{
+		{name: "no runs", r: FuzzRange{}, want: "-"},
+		{name: "ok", r: FuzzRange{Counts: map[Termination]int{GracefulSuccess: 3}}, want: "ok"},
+		{name: "hang", r: FuzzRange{Counts: map[Termination]int{GracefulSuccess: 1, ForceKilled: 1}}, want: "hang"},
+		{name: "crash", r: FuzzRange{Counts: map[Termination]int{GracefulError: 1}}, want: "crash"},
+		{name: "exited", r: FuzzRange{Counts: map[Termination]int{GracefulSuccess: 1}, Exited: 1}, want: "crash"},
+		{name: "only exited", r: FuzzRange{Exited: 2}, want: "crash"},
+		{name: "hang and exited", r: FuzzRange{Counts: map[Termination]int{SignalIgnored: 1}, Exited: 1}, want: "hang+crash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.Verdict(); got != tt.want {
+				t.Errorf("Verdict() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuzzBucket(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		want  int
+	}{
+		{delay: 0, want: 0},
+		{delay: 999 * time.Millisecond, want: 0},
+		{delay: time.Second, want: 1},
+		{delay: 4500 * time.Millisecond, want: 4},
+		{delay: 7 * time.Second, want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.delay.String(), func(t *testing.T) {
+			if got := fuzzBucket(tt.delay, time.Second, 5); got != tt.want {
+				t.Errorf("fuzzBucket(%s) = %d, want %d", tt.delay, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFuzzPhase(t *testing.T) {
+	tests := []struct {
+		from, to time.Duration
+		want     string
+	}{
+		{from: 0, to: time.Second, want: phaseStarting},
+		{from: time.Second, to: 2 * time.Second, want: phaseStarting},
+		{from: 2 * time.Second, to: 3 * time.Second, want: phaseReady},
+		{from: time.Second, to: 3 * time.Second, want: phaseStarting + "/" + phaseReady},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from.String()+"-"+tt.to.String(), func(t *testing.T) {
+			if got := fuzzPhase(tt.from, tt.to, 2*time.Second); got != tt.want {
+				t.Errorf("fuzzPhase(%s, %s) = %q, want %q", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
 	"github.com/olekukonko/tablewriter"
 )
 
@@ -31,7 +33,7 @@ const (
 	GracefulError
 
 	// The container did not terminate gracefully. Specifically, it failed to terminate
-	// within the allocated StopTimeout, triggering a SIGKILL by the container daemon.
+	// within the allocated StopTimeout, triggering a SIGKILL.
 	ForceKilled
 
 	// The container did not terminate gracefully. During the shutdown it requested more
@@ -45,11 +47,22 @@ const (
 	// configured to exit with one of those two status codes and this either happened by
 	// chance or as a response to the SIGTERM signal.
 	Unhandled
+
+	// The container did not terminate gracefully. The first signal of the escalation
+	// policy had no observable effect: the container neither logged anything nor
+	// changed its process list before grace moved on to the next signal.
+	SignalIgnored
+
+	// The container terminated gracefully and the exit code was zero, but the
+	// post-stop verification failed: its files, the verification container or the
+	// checks of its service profile tell that the application did not shut down
+	// cleanly.
+	Unverified
 )
 
 func (d Termination) String() string {
 	return [...]string{
-		"GracefulSuccess", "GracefulError", "ForceKilled", "OOMKilled", "Unhandled",
+		"GracefulSuccess", "GracefulError", "ForceKilled", "OOMKilled", "Unhandled", "SignalIgnored", "Unverified",
 	}[d]
 }
 
@@ -57,10 +70,99 @@ func (d Termination) String() string {
 type Input struct {
 	Containers []string
 	Docker     *client.Client
+
+	// Diagnose is the fraction of the stop timeout after which diagnostics are
+	// collected from a container that is still running, zero disables it
+	Diagnose float64
+
+	// DiagnoseSignal is sent to the container before collecting diagnostics, so
+	// runtimes such as the JVM can write a thread dump
+	DiagnoseSignal string
+
+	// Escalation is the signal sequence used to stop the containers, if empty we do
+	// what the container daemon would do given the container's configuration
+	Escalation []Step
+
+	// Signal and Timeout, if set, replace the first signal of the escalation policy
+	// and how long to wait for it. With a Pod, Timeout is its grace period instead,
+	// which the preStop hook takes its share of.
+	Signal  string
+	Timeout time.Duration
+
+	// Signals are tested one by one against fresh containers of each target
+	Signals []string
+
+	// Resend is how many times the first signal is sent again while waiting for
+	// the container to exit, once every ResendInterval
+	Resend         int
+	ResendInterval time.Duration
+
+	// Repeat is how many fresh containers of each target are stopped, in order to
+	// detect flaky terminations
+	Repeat int
+
+	// Mode tells if Containers are stopped directly, cloned or are in fact images
+	Mode Mode
+
+	// CloneVolumes is what copies of containers do with their writable volumes
+	CloneVolumes CloneVolumes
+
+	// Startup is how long fresh containers are given to start before being stopped
+	Startup time.Duration
+
+	// Readiness tells when fresh containers are ready, after their startup
+	Readiness Readiness
+
+	// Stress is the resource pressure fresh containers are stopped under
+	Stress Stress
+
+	// Platform, if set, replaces the container daemon's stop signal and timeout
+	// with those of the platform the containers run on in production
+	Platform *Platform
+
+	// TrackSockets samples the sockets of the containers while they stop, to tell
+	// when they closed their listeners and connections
+	TrackSockets bool
+
+	// Verify is checked once each container exited
+	Verify Verify
+
+	// Profiles are the service profiles selected for each target, by name, or for
+	// every target under the empty name, where profileAuto detects them from the
+	// image; the others have none
+	Profiles map[string]string
+
+	// Stale tells to look for files left behind that may prevent a restart, which
+	// are those matching the built-in patterns or StalePatterns
+	Stale         bool
+	StalePatterns []string
+
+	// Pod, if set, makes grace stop containers like the kubelet would stop the
+	// corresponding container of a Kubernetes Pod
+	Pod *Pod
+
+	// Hooks run around each stop, and make the test fail when they fail if
+	// HookStrict is set
+	Hooks      []Hook
+	HookStrict bool
+
+	// Chaos is injected on the dependencies of the containers right before or
+	// after the stop signal, as told by ChaosAt
+	Chaos   []Chaos
+	ChaosAt string
+
+	// WhatIf tells to try candidate fixes on fresh containers of the targets that
+	// failed to stop gracefully
+	WhatIf bool
 }
 
 // Output is the main output structure to the program
 type Output struct {
+	// Target is the container or image given as argument, which may differ from
+	// the container that was stopped when it is a fresh one
+	Target string
+
+	ID      string
 	ShortID string
 	Image   string
 	Command string
@@ -68,8 +170,50 @@ type Output struct {
 	Timeout     time.Duration
 	Termination Termination
 
+	// StopSignal is the stop signal configured for the container, FirstSignal is
+	// the one that was actually sent first and Signal is the signal of the
+	// escalation step that ended the container, whose position in the policy is
+	// Step, starting at 1
+	StopSignal  string
+	FirstSignal string
+	Signal      string
+	Step        int
+
 	ExitCode     int
-	StopDuration int
+	StopDuration time.Duration
+
+	// Signaled is when the first signal was sent
+	Signaled time.Time
+
+	// Resend is the outcome of sending the first signal again Resent times, only
+	// set if it was requested
+	Resend string
+	Resent int
+
+	// PreStop is only set when emulating a Kubernetes Pod
+	PreStop *PreStop
+
+	// Diagnostics is only set for containers that had to be killed
+	Diagnostics *Diagnostics
+
+	// Sockets is only set if socket tracking was requested
+	Sockets *SocketLifecycle
+
+	// Verification is only set if post-stop verification was requested
+	Verification *Verification
+
+	// Profile is only set for services with a profile, given or detected
+	Profile *ProfileCheck
+
+	// StaleFiles are the lock, PID and socket files left behind, if requested
+	StaleFiles []StaleFile
+
+	// Recommendations are specific to the runtimes detected in the container
+	Recommendations []Recommendation
+
+	// WhatIf are the outcomes of the candidate fixes, only set if requested and
+	// the container failed to stop gracefully
+	WhatIf []WhatIf
 }
 
 func main() {
@@ -97,23 +241,29 @@ Copyright:
 	app := &cli.App{
 		Name:        "Grace",
 		Usage:       "validates if containerized applications terminate gracefully.",
-		UsageText:   "grace [CONTAINER [CONTAINER ...]]",
+		UsageText:   "grace [OPTIONS] [CONTAINER|IMAGE [CONTAINER|IMAGE ...]]",
 		Description: "Validates if containerized applications terminate gracefully.",
+		Flags:       flags,
+		Commands: []*cli.Command{
+			sweepCommand,
+			fuzzCommand,
+			crashCommand,
+			reloadCommand,
+			deployCommand,
+			lintCommand,
+			lintDockerfileCommand,
+		},
 		Action: func(c *cli.Context) error {
 			if c.NArg() == 0 {
 				cli.ShowAppHelpAndExit(c, 0)
 			}
 
-			docker, err := client.NewClientWithOpts(client.FromEnv)
+			in, err := parseInput(c)
 
 			if err != nil {
 				return err
 			}
 
-			in := Input{}
-			in.Containers = c.Args().Slice()
-			in.Docker = docker
-
 			if err := run(in, os.Stdout); err != nil {
 				return err
 			}
@@ -127,41 +277,546 @@ Copyright:
 	}
 }
 
+var flags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "clone",
+		Usage: "stop fresh copies of the containers instead of the containers themselves",
+	},
+	&cli.StringFlag{
+		Name:  "clone-volumes",
+		Usage: "what copies do with the writable volumes and bind mounts of the containers: `POLICY` refuse, fresh (empty anonymous volumes) or shared",
+		Value: string(VolumesRefuse),
+	},
+	&cli.BoolFlag{
+		Name:  "image",
+		Usage: "arguments are images, stop fresh containers run from them",
+	},
+	&cli.DurationFlag{
+		Name:  "startup",
+		Usage: "give fresh containers this `DURATION` to start before stopping them",
+		Value: time.Second,
+	},
+	&cli.StringFlag{
+		Name:  "ready-log",
+		Usage: "fresh containers are ready once they log a line matching this `REGEX`",
+	},
+	&cli.StringFlag{
+		Name:  "ready-http",
+		Usage: "fresh containers are ready once this `PORT/PATH` of their IP address answers with a 2xx status",
+	},
+	&cli.DurationFlag{
+		Name:  "ready-timeout",
+		Usage: "give up on fresh containers that are not ready after this `DURATION`",
+		Value: time.Minute,
+	},
+	&cli.Float64Flag{
+		Name:  "cpus",
+		Usage: "limit fresh containers to this `NUMBER` of CPUs",
+	},
+	&cli.StringFlag{
+		Name:  "memory",
+		Usage: "limit fresh containers to this `SIZE` of memory, without swap (e.g. 64m)",
+	},
+	&cli.StringSliceFlag{
+		Name:  "device-read-bps",
+		Usage: "limit the read rate of fresh containers from a device (e.g. /dev/sda:1mb)",
+	},
+	&cli.StringSliceFlag{
+		Name:  "device-write-bps",
+		Usage: "limit the write rate of fresh containers to a device (e.g. /dev/sda:1mb)",
+	},
+	&cli.IntFlag{
+		Name:  "cpu-burners",
+		Usage: "run `N` containers spinning a CPU each while fresh containers are stopped",
+	},
+	&cli.StringFlag{
+		Name:  "burner-image",
+		Usage: "run CPU burners from this `IMAGE`, which must have a shell",
+		Value: defaultBurnerImage,
+	},
+	&cli.StringFlag{
+		Name:  "platform",
+		Usage: "stop containers like this `PLATFORM` would: docker, kubernetes, ecs, cloudrun, heroku or fly",
+	},
+	&cli.StringFlag{
+		Name:  "platform-config",
+		Usage: "read the stop semantics from this `FILE`: a fly.toml for fly, a task definition for ecs or a Pod spec for kubernetes",
+	},
+	&cli.StringFlag{
+		Name:  "platform-container",
+		Usage: "use the container with this `NAME` of the task definition or Pod spec (default: the first one)",
+	},
+	&cli.StringFlag{
+		Name:  "pod",
+		Usage: "run the preStop hook and use the grace period of the Kubernetes Pod spec in this YAML `FILE`, like the kubelet does",
+	},
+	&cli.StringFlag{
+		Name:  "pod-container",
+		Usage: "emulate the container with this `NAME` of the Pod spec (default: the first one)",
+	},
+	&cli.StringSliceFlag{
+		Name:  "hook",
+		Usage: "run a shell command at a stage of each stop, given as `STAGE=COMMAND`; prefix the command with exec: to run it inside the container (stages: before-ready, before-stop, after-signal, after-exit, after-report)",
+	},
+	&cli.BoolFlag{
+		Name:  "hook-strict",
+		Usage: "fail when a hook fails, instead of just logging it",
+	},
+	&cli.StringSliceFlag{
+		Name:  "chaos",
+		Usage: "while stopping, disconnect the container from a network or pause or stop a dependency container, given as `ACTION:TARGET` (e.g. disconnect:backend, pause:postgres, stop:redis)",
+	},
+	&cli.StringFlag{
+		Name:  "chaos-at",
+		Usage: "inject chaos `WHEN` the stop signal is sent, either before or after",
+		Value: ChaosAfter,
+	},
+	&cli.BoolFlag{
+		Name:  "track-sockets",
+		Usage: "report when containers closed their listening sockets and connections after the signal, requires grace to run on the container host",
+	},
+	&cli.StringSliceFlag{
+		Name:  "verify-file",
+		Usage: "once stopped, assert that a `FILE` of the container exists, is absent if prefixed with ! or matches a regex given as FILE=REGEX",
+	},
+	&cli.StringFlag{
+		Name:  "verify-image",
+		Usage: "once stopped, run a container from this `IMAGE` with the volumes of the stopped one, which must exit with code zero",
+	},
+	&cli.StringFlag{
+		Name:  "verify-command",
+		Usage: "run this shell `COMMAND` in the verification container instead of its default command",
+	},
+	&cli.StringSliceFlag{
+		Name:  "profile",
+		Usage: "check the clean shutdown of a stateful service, given as `[TARGET=]PROFILE` for one or every target: postgres, mysql, redis, mongodb, auto to detect it from the image, or none",
+	},
+	&cli.BoolFlag{
+		Name:  "stale-files",
+		Usage: "once stopped, report the lock, PID and socket files created by the container that were left behind",
+	},
+	&cli.StringSliceFlag{
+		Name:  "stale-pattern",
+		Usage: "also report left behind files whose name matches this `PATTERN` (e.g. *.tmp)",
+	},
+	&cli.BoolFlag{
+		Name:  "what-if",
+		Usage: "when a container is force killed or ignores its signal, stop fresh copies of it with candidate fixes and recommend one that terminates gracefully",
+	},
+	&cli.StringFlag{
+		Name:  "escalation",
+		Usage: "stop containers with this signal `POLICY` instead of their stop signal and timeout (e.g. SIGTERM,5s,SIGINT,5s,SIGKILL)",
+	},
+	&cli.StringFlag{
+		Name:  "signals",
+		Usage: "test each of these `SIGNALS` against fresh containers, requires --clone or --image (e.g. TERM,INT,QUIT,HUP)",
+	},
+	&cli.IntFlag{
+		Name:  "resend",
+		Usage: "send the first signal again up to `N` times while waiting for containers to exit",
+	},
+	&cli.DurationFlag{
+		Name:  "resend-interval",
+		Usage: "wait this `DURATION` between each time the first signal is sent",
+		Value: time.Second,
+	},
+	&cli.IntFlag{
+		Name:  "repeat",
+		Usage: "stop `N` fresh containers of each target and aggregate the results, requires --clone or --image",
+		Value: 1,
+	},
+	&cli.Float64Flag{
+		Name:  "diagnose",
+		Usage: "collect diagnostics from containers still running after this `FRACTION` of their stop timeout (e.g. 0.8)",
+	},
+	&cli.StringFlag{
+		Name:  "diagnose-signal",
+		Usage: "send this `SIGNAL` before collecting diagnostics (e.g. SIGQUIT for a JVM thread dump)",
+	},
+}
+
+var (
+	// runFlags only apply to the main command
+	runFlags = []string{"repeat", "signals", "what-if"}
+
+	// stopFlags only apply when grace stops the containers itself
+	stopFlags = []string{
+		"escalation", "resend", "resend-interval", "diagnose", "diagnose-signal",
+		"platform", "platform-config", "platform-container", "pod", "pod-container",
+		"chaos", "chaos-at", "track-sockets", "verify-file", "verify-image",
+		"verify-command", "profile", "stale-files", "stale-pattern", "cpu-burners",
+		"burner-image",
+	}
+)
+
+// commandFlags returns the flags of a subcommand: those of the main command it
+// supports, followed by its own
+func commandFlags(own []cli.Flag, unsupported ...[]string) []cli.Flag {
+	var supported []cli.Flag
+
+	for _, f := range flags {
+		var skip bool
+
+		for _, names := range unsupported {
+			skip = skip || contains(names, f.Names()[0])
+		}
+
+		if !skip {
+			supported = append(supported, f)
+		}
+	}
+
+	return append(supported, own...)
+}
+
+func parseInput(c *cli.Context) (Input, error) {
+	docker, err := client.NewClientWithOpts(client.FromEnv)
+
+	if err != nil {
+		return Input{}, err
+	}
+
+	in := Input{}
+	in.Containers = c.Args().Slice()
+	in.Docker = docker
+	in.Startup = c.Duration("startup")
+	in.Repeat = c.Int("repeat")
+	in.Resend = c.Int("resend")
+	in.ResendInterval = c.Duration("resend-interval")
+	in.Readiness.HTTP = c.String("ready-http")
+	in.Readiness.Timeout = c.Duration("ready-timeout")
+	in.Diagnose = c.Float64("diagnose")
+	in.DiagnoseSignal = c.String("diagnose-signal")
+
+	if in.Diagnose < 0 || in.Diagnose >= 1 {
+		return Input{}, fmt.Errorf("diagnose must be a fraction between 0 and 1, got %v", in.Diagnose)
+	}
+
+	switch {
+	case c.Bool("clone") && c.Bool("image"):
+		return Input{}, fmt.Errorf("clone and image are mutually exclusive")
+	case c.Bool("clone"):
+		in.Mode = Clone
+	case c.Bool("image"):
+		in.Mode = FromImage
+	}
+
+	switch in.CloneVolumes = CloneVolumes(c.String("clone-volumes")); in.CloneVolumes {
+	case VolumesRefuse, VolumesFresh, VolumesShared:
+	default:
+		return Input{}, fmt.Errorf("clone-volumes must be %s, %s or %s, got %q", VolumesRefuse, VolumesFresh, VolumesShared, in.CloneVolumes)
+	}
+
+	if c.IsSet("ready-log") {
+		if in.Readiness.Log, err = regexp.Compile(c.String("ready-log")); err != nil {
+			return Input{}, err
+		}
+	}
+
+	if err := parseStress(c, &in); err != nil {
+		return Input{}, err
+	}
+
+	in.HookStrict = c.Bool("hook-strict")
+
+	if c.IsSet("platform") {
+		if in.Platform, in.Pod, err = loadPlatform(c.String("platform"), c.String("platform-config"), c.String("platform-container")); err != nil {
+			return Input{}, err
+		}
+	}
+
+	if c.IsSet("pod") {
+		if in.Platform != nil && in.Pod == nil {
+			return Input{}, fmt.Errorf("pod requires the kubernetes platform")
+		}
+
+		if in.Pod, err = loadPod(c.String("pod"), c.String("pod-container")); err != nil {
+			return Input{}, err
+		}
+	}
+
+	in.TrackSockets = c.Bool("track-sockets")
+	in.Verify.Image = c.String("verify-image")
+	in.Verify.Command = c.String("verify-command")
+
+	if in.Verify.Command != "" && in.Verify.Image == "" {
+		return Input{}, fmt.Errorf("verify-command requires verify-image")
+	}
+
+	if in.Verify.Files, err = parseFileAssertions(c.StringSlice("verify-file")); err != nil {
+		return Input{}, err
+	}
+
+	if in.Profiles, err = parseProfiles(c.StringSlice("profile")); err != nil {
+		return Input{}, err
+	}
+
+	in.Stale = c.Bool("stale-files") || c.IsSet("stale-pattern")
+	in.StalePatterns = c.StringSlice("stale-pattern")
+
+	if in.Hooks, err = parseHooks(c.StringSlice("hook")); err != nil {
+		return Input{}, err
+	}
+
+	if in.Chaos, err = parseChaos(c.StringSlice("chaos")); err != nil {
+		return Input{}, err
+	}
+
+	if in.ChaosAt = c.String("chaos-at"); len(in.Chaos) > 0 && in.ChaosAt != ChaosBefore && in.ChaosAt != ChaosAfter {
+		return Input{}, fmt.Errorf("chaos-at must be either %s or %s", ChaosBefore, ChaosAfter)
+	}
+
+	if in.Resend < 0 || (in.Resend > 0 && in.ResendInterval <= 0) {
+		return Input{}, fmt.Errorf("resend must not be negative and its interval must be positive")
+	}
+
+	if c.IsSet("repeat") && in.Repeat < 1 {
+		return Input{}, fmt.Errorf("repeat must be at least 1, got %d", in.Repeat)
+	}
+
+	if in.Repeat > 1 && !in.Mode.fresh() {
+		return Input{}, fmt.Errorf("repeat requires either clone or image")
+	}
+
+	if c.IsSet("escalation") {
+		if in.Escalation, err = parseEscalation(c.String("escalation")); err != nil {
+			return Input{}, err
+		}
+	}
+
+	if c.IsSet("signals") {
+		for _, signal := range strings.Split(c.String("signals"), ",") {
+			if signal = strings.TrimSpace(signal); signal != "" {
+				in.Signals = append(in.Signals, normalizeSignal(signal))
+			}
+		}
+
+		if !in.Mode.fresh() {
+			return Input{}, fmt.Errorf("signals requires either clone or image")
+		}
+	}
+
+	if in.WhatIf = c.Bool("what-if"); in.WhatIf && (in.Repeat > 1 || len(in.Signals) > 0) {
+		return Input{}, fmt.Errorf("what-if cannot be combined with repeat or signals")
+	}
+
+	return in, nil
+}
+
+// parseStress reads the stress options, which only apply to fresh containers
+func parseStress(c *cli.Context, in *Input) error {
+	var err error
+
+	in.Stress.NanoCPUs = int64(c.Float64("cpus") * 1e9)
+	in.Stress.Burners = c.Int("cpu-burners")
+	in.Stress.BurnerImage = c.String("burner-image")
+
+	if c.IsSet("memory") {
+		if in.Stress.Memory, err = units.RAMInBytes(c.String("memory")); err != nil {
+			return err
+		}
+	}
+
+	if in.Stress.ReadBps, err = parseThrottleDevices(c.StringSlice("device-read-bps")); err != nil {
+		return err
+	}
+
+	if in.Stress.WriteBps, err = parseThrottleDevices(c.StringSlice("device-write-bps")); err != nil {
+		return err
+	}
+
+	if in.Stress.NanoCPUs < 0 || in.Stress.Memory < 0 || in.Stress.Burners < 0 {
+		return fmt.Errorf("stress options must not be negative")
+	}
+
+	stressed := in.Stress.NanoCPUs > 0 || in.Stress.Memory > 0 || len(in.Stress.ReadBps) > 0 || len(in.Stress.WriteBps) > 0 || in.Stress.Burners > 0
+
+	if stressed && !in.Mode.fresh() {
+		return fmt.Errorf("stress options require either clone or image")
+	}
+
+	return nil
+}
+
 func run(in Input, writer io.Writer) error {
+	if in.Repeat > 1 {
+		return runRepeat(in, writer)
+	}
+
+	if len(in.Signals) > 0 {
+		return runMatrix(in, writer)
+	}
+
 	var data []Output
 
 	for _, c := range in.Containers {
 
-		out, err := analyze(context.Background(), in.Docker, c)
+		out, err := runOnce(context.Background(), in, c)
 
 		if err != nil {
 			return err
 		}
 
+		if in.WhatIf && out.Termination.remediable() {
+			out.WhatIf = whatIf(context.Background(), in, c, out)
+		}
+
 		data = append(data, out)
 	}
 
 	write(writer, data)
 
-	return nil
+	return afterReport(context.Background(), in, data)
 }
 
-func analyze(ctx context.Context, docker *client.Client, c string) (Output, error) {
-	json, err := docker.ContainerInspect(ctx, c)
+// runOnce stops a single container for the given target, creating a fresh one if
+// the mode requires it
+func runOnce(ctx context.Context, in Input, target string) (Output, error) {
+	c, err := provision(ctx, in, target)
 
 	if err != nil {
 		return Output{}, err
 	}
 
-	shortID := json.ID[:12]
+	return stopProvisioned(ctx, in, target, c)
+}
+
+// stopProvisioned stops and then removes a container provisioned for the given
+// target, under the resource pressure of the input
+func stopProvisioned(ctx context.Context, in Input, target, c string) (Output, error) {
+	defer cleanup(ctx, in, c)
+
+	burners, err := startBurners(ctx, in)
+
+	if err != nil {
+		return Output{}, err
+	}
+
+	defer stopBurners(ctx, in, burners)
+
+	return analyze(ctx, in, target, c)
+}
+
+// analyze stops a container for the given target and tells how it terminated
+func analyze(ctx context.Context, in Input, target, c string) (Output, error) {
+	p, err := prepareStop(ctx, in, target, c)
+
+	if err != nil {
+		return Output{}, err
+	}
+
+	return stopPrepared(ctx, in, target, p)
+}
+
+// preparedStop is what is known about a running container right before it is
+// stopped
+type preparedStop struct {
+	json       types.ContainerJSON
+	runtimes   []Runtime
+	stopSignal string
+	steps      []Step
+
+	// profile is the service profile of the target, if it has one
+	profile  *Profile
+	detected bool
+}
+
+// prepareStop does everything that comes before the first signal, so that the
+// signal can then be sent at a precise time
+func prepareStop(ctx context.Context, in Input, target, c string) (preparedStop, error) {
+	docker := in.Docker
+
+	json, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return preparedStop{}, err
+	}
 
 	// see if is runnning
 	if !json.State.Running {
-		return Output{}, fmt.Errorf("container %s is not running", shortID)
+		return preparedStop{}, fmt.Errorf("container %s is not running", json.ID[:12])
+	}
+
+	// the processes are only there to tell the runtimes before the stop
+	runtimes := detectRuntimes(ctx, docker, json)
+
+	stopSignal := normalizeSignal(json.Config.StopSignal)
+
+	if json.Config.StopSignal == "" {
+		stopSignal = defaultStopSignal
+	}
+
+	profile, detected := selectProfile(in, target, json.Config.Image)
+
+	steps := in.Escalation
+
+	if len(steps) == 0 {
+		signal, timeout := stopSignal, getStopTimeout(json.Config.StopTimeout)
+
+		// a service that sets no stop signal of its own is sent the one it shuts
+		// down cleanly on
+		if profile != nil && json.Config.StopSignal == "" {
+			signal = profile.Signal
+		}
+
+		// platforms may not care about the container's configuration
+		if p := in.Platform; p != nil {
+			if p.Signal != "" {
+				signal = p.Signal
+			}
+
+			if p.Timeout > 0 {
+				timeout = p.Timeout
+			}
+		}
+
+		steps = defaultEscalation(signal, timeout)
+	}
+
+	if in.Signal != "" || in.Timeout > 0 {
+		first := steps[0]
+
+		if in.Signal != "" {
+			first.Signal = in.Signal
+		}
+
+		if in.Timeout > 0 {
+			first.Wait = in.Timeout
+		}
+
+		steps = append([]Step{first}, steps[1:]...)
+	}
+
+	if err := runHooks(ctx, in, HookEvent{Stage: HookBeforeStop, ContainerID: json.ID}); err != nil {
+		return preparedStop{}, err
+	}
+
+	return preparedStop{json: json, runtimes: runtimes, stopSignal: stopSignal, steps: steps, profile: profile, detected: detected}, nil
+}
+
+// stopPrepared stops a prepared container for the given target and tells how it
+// terminated
+func stopPrepared(ctx context.Context, in Input, target string, prepared preparedStop) (Output, error) {
+	docker := in.Docker
+	json, runtimes, stopSignal, steps := prepared.json, prepared.runtimes, prepared.stopSignal, prepared.steps
+	c := json.ID
+	shortID := json.ID[:12]
+
+	var preStop *PreStop
+
+	// the kubelet runs the preStop hook first, and gives what is left of the grace
+	// period to the application
+	if in.Pod != nil {
+		p := runPreStop(ctx, in, json)
+		preStop = &p
+
+		first := steps[0]
+		first.Wait = preStop.Remaining
+		steps = append([]Step{first}, steps[1:]...)
 	}
 
 	// try to gracefully stop the container
-	stopDuration, err := stopContainer(ctx, docker, c)
+	stop, err := stopContainer(ctx, in, c, steps)
 
 	if err != nil {
 		return Output{}, err
@@ -182,26 +837,100 @@ func analyze(ctx context.Context, docker *client.Client, c string) (Output, erro
 		command = command[0:27] + "..."
 	}
 
-	stopTimeout := defaultStopTimeout
-
-	if json.Config.StopTimeout != nil {
-		stopTimeout = time.Second * time.Duration(*json.Config.StopTimeout)
-	}
-
 	out := Output{
+		Target:       target,
+		ID:           json.ID,
 		ShortID:      shortID,
 		ExitCode:     json.State.ExitCode,
 		Image:        json.Config.Image,
 		Command:      command,
-		Timeout:      stopTimeout,
-		Termination:  getTerminationState(*json.State, stopDuration, stopTimeout),
-		StopDuration: int(stopDuration / time.Second),
+		Timeout:      stop.Timeout,
+		Termination:  getTerminationState(*json.State, stop),
+		StopSignal:   stopSignal,
+		FirstSignal:  steps[0].Signal,
+		Signal:       stop.Signal,
+		Step:         stop.Step,
+		StopDuration: stop.Duration,
+		Signaled:     stop.Signaled,
+		PreStop:      preStop,
+		Sockets:      stop.Sockets,
+	}
+
+	out.Recommendations = recommend(runtimes, json.Config, out)
+
+	if in.Resend > 0 {
+		out.Resend = getResendOutcome(out.Termination, stop)
+		out.Resent = stop.Resent
+	}
+
+	// diagnostics are only relevant if the container was in fact stuck
+	if stop.Signal == killSignal {
+		out.Diagnostics = stop.Diagnostics
+	}
+
+	if in.Verify.configured() {
+		out.Verification = verifyStop(ctx, in, c)
+
+		if out.Termination == GracefulSuccess && len(out.Verification.Failures) > 0 {
+			out.Termination = Unverified
+		}
+	}
+
+	if prepared.profile != nil {
+		out.Profile = checkProfile(ctx, in, json, prepared.profile, out.FirstSignal, stop.Signaled)
+		out.Profile.Detected = prepared.detected
+
+		if out.Termination == GracefulSuccess && len(out.Profile.Failures) > 0 {
+			out.Termination = Unverified
+		}
+	}
+
+	if in.Stale {
+		if out.StaleFiles, err = findStaleFiles(ctx, in, json); err != nil {
+			return Output{}, err
+		}
 	}
 
+	err = runHooks(ctx, in, out.hookEvent(HookAfterExit))
+
 	return out, err
 }
 
-func getTerminationState(state types.ContainerState, stopDuration, stopTimeout time.Duration) Termination {
+// hookEvent describes the output to the hooks of the given stage
+func (out Output) hookEvent(stage string) HookEvent {
+	return HookEvent{
+		Stage:       stage,
+		ContainerID: out.ID,
+		Signal:      out.FirstSignal,
+		SignalTime:  &out.Signaled,
+		Result:      newHookResult(out),
+	}
+}
+
+// afterReport runs the after-report hooks of each output
+func afterReport(ctx context.Context, in Input, data []Output) error {
+	for _, out := range data {
+		if err := runHooks(ctx, in, out.hookEvent(HookAfterReport)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getStopTimeout(timeout *int) time.Duration {
+	if timeout == nil {
+		return defaultStopTimeout
+	}
+
+	return time.Second * time.Duration(*timeout)
+}
+
+func getTerminationState(state types.ContainerState, stop Stop) Termination {
+	if stop.Ignored && !state.OOMKilled {
+		return SignalIgnored
+	}
+
 	if state.ExitCode == 0 {
 		return GracefulSuccess
 	}
@@ -214,7 +943,7 @@ func getTerminationState(state types.ContainerState, stopDuration, stopTimeout t
 
 	if isSIGKILL {
 
-		if stopDuration >= stopTimeout {
+		if stop.Signal == killSignal {
 			return ForceKilled
 		}
 
@@ -224,14 +953,8 @@ func getTerminationState(state types.ContainerState, stopDuration, stopTimeout t
 	return GracefulError
 }
 
-func stopContainer(ctx context.Context, docker *client.Client, c string) (time.Duration, error) {
-	start := time.Now()
-	err := docker.ContainerStop(ctx, c, nil)
-	return time.Since(start), err
-}
-
 func write(writer io.Writer, data []Output) {
-	table := tablewriter.NewWriter(writer)
+	table := newTable(writer)
 
 	var rows [][]string
 
@@ -241,15 +964,34 @@ func write(writer io.Writer, data []Output) {
 			out.Image,
 			fmt.Sprintf("%5s", out.Command),
 			fmt.Sprint(out.Termination.String()),
+			out.Signal,
+			strconv.Itoa(out.Step),
 			strconv.FormatInt(int64(out.ExitCode), 10),
-			fmt.Sprintf("%3ss/%ss", strconv.FormatInt(int64(out.StopDuration), 10), strconv.FormatInt(int64(out.Timeout/time.Second), 10)),
+			fmt.Sprintf("%3ss/%ss", strconv.FormatInt(int64(out.StopDuration/time.Second), 10), strconv.FormatInt(int64(out.Timeout/time.Second), 10)),
 		})
 	}
 
 	table.SetHeader([]string{
-		"ID", "IMAGE", "COMMAND", "TERMINATION", "EXIT CODE", "DURATION",
+		"ID", "IMAGE", "COMMAND", "TERMINATION", "SIGNAL", "STEP", "EXIT CODE", "DURATION",
 	})
 
+	table.AppendBulk(rows)
+	table.Render()
+
+	writeResend(writer, data)
+	writePreStop(writer, data)
+	writeSockets(writer, data)
+	writeVerification(writer, data)
+	writeProfiles(writer, data)
+	writeStaleFiles(writer, data)
+	writeDiagnostics(writer, data)
+	writeRecommendations(writer, data)
+	writeWhatIf(writer, data)
+}
+
+func newTable(writer io.Writer) *tablewriter.Table {
+	table := tablewriter.NewWriter(writer)
+
 	table.SetAutoWrapText(false)
 	table.SetAutoFormatHeaders(true)
 	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
@@ -262,6 +1004,5 @@ func write(writer io.Writer, data []Output) {
 	table.SetTablePadding("\t")
 	table.SetNoWhiteSpace(true)
 
-	table.AppendBulk(rows)
-	table.Render()
+	return table
 }
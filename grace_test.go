@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestCommandFlags(t *testing.T) {
+	own := &cli.StringFlag{Name: "own"}
+
+	names := map[string]bool{}
+
+	for _, f := range commandFlags([]cli.Flag{own}, runFlags, stopFlags) {
+		names[f.Names()[0]] = true
+	}
+
+	all := map[string]bool{}
+
+	for _, f := range flags {
+		all[f.Names()[0]] = true
+	}
+
+	for _, name := range append(append([]string{}, runFlags...), stopFlags...) {
+		if !all[name] {
+			t.Errorf("the unsupported flag %q does not exist", name)
+		}
+
+		if names[name] {
+			t.Errorf("commandFlags() kept the unsupported flag %q", name)
+		}
+	}
+
+	for _, name := range []string{"clone", "image", "startup", "hook", "own"} {
+		if !names[name] {
+			t.Errorf("commandFlags() dropped the flag %q", name)
+		}
+	}
+
+	if len(commandFlags(nil)) != len(flags) {
+		t.Errorf("commandFlags() dropped flags without unsupported ones")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The stages at which hooks run around each stop
+const (
+	// HookBeforeReady runs once a fresh container started, before waiting for it to
+	// be ready.
+	HookBeforeReady = "before-ready"
+
+	// HookBeforeStop runs right before the first signal is sent.
+	HookBeforeStop = "before-stop"
+
+	// HookAfterSignal runs right after the first signal is sent.
+	HookAfterSignal = "after-signal"
+
+	// HookAfterExit runs once the container exited and its termination is known.
+	HookAfterExit = "after-exit"
+
+	// HookAfterReport runs once the results of all containers are written.
+	HookAfterReport = "after-report"
+)
+
+var hookStages = []string{
+	HookBeforeReady, HookBeforeStop, HookAfterSignal, HookAfterExit, HookAfterReport,
+}
+
+// execPrefix marks hooks that run inside the container rather than on the host
+const execPrefix = "exec:"
+
+// Hook is a shell command run at some stage of each stop, either on the host or,
+// with docker exec, inside the container (which must then be running)
+type Hook struct {
+	Stage   string
+	Command string
+	Exec    bool
+}
+
+// HookEvent is what hooks receive as JSON on their standard input, and also as
+// GRACE_* environment variables
+type HookEvent struct {
+	Stage       string      `json:"stage"`
+	ContainerID string      `json:"containerId"`
+	Signal      string      `json:"signal,omitempty"`
+	SignalTime  *time.Time  `json:"signalTime,omitempty"`
+	Result      *HookResult `json:"result,omitempty"`
+}
+
+// HookResult is the result of a stop, as given to hooks
+type HookResult struct {
+	Termination string  `json:"termination"`
+	Signal      string  `json:"signal"`
+	Step        int     `json:"step"`
+	ExitCode    int     `json:"exitCode"`
+	Duration    float64 `json:"duration"`
+}
+
+// newHookResult converts the output of a stop for hooks
+func newHookResult(out Output) *HookResult {
+	return &HookResult{
+		Termination: out.Termination.String(),
+		Signal:      out.Signal,
+		Step:        out.Step,
+		ExitCode:    out.ExitCode,
+		Duration:    out.StopDuration.Seconds(),
+	}
+}
+
+// parseHooks parses hooks such as "before-stop=./start-traffic.sh" or
+// "after-signal=exec:cat /tmp/state"
+func parseHooks(values []string) ([]Hook, error) {
+	var hooks []Hook
+
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("hook %q must be in the form STAGE=COMMAND", value)
+		}
+
+		if !contains(hookStages, parts[0]) {
+			return nil, fmt.Errorf("unknown hook stage %q, must be one of %s", parts[0], strings.Join(hookStages, ", "))
+		}
+
+		hook := Hook{Stage: parts[0], Command: parts[1]}
+
+		if strings.HasPrefix(hook.Command, execPrefix) {
+			hook.Exec = true
+			hook.Command = strings.TrimPrefix(hook.Command, execPrefix)
+		}
+
+		hooks = append(hooks, hook)
+	}
+
+	return hooks, nil
+}
+
+// checkReportHooks rejects after-report hooks run inside fresh containers, which
+// were removed by the time the results are reported
+func checkReportHooks(in Input) error {
+	if !in.Mode.fresh() {
+		return nil
+	}
+
+	for _, hook := range in.Hooks {
+		if hook.Stage == HookAfterReport && hook.Exec {
+			return fmt.Errorf("%s hook %q cannot run inside fresh containers, which are removed before the report", hook.Stage, execPrefix+hook.Command)
+		}
+	}
+
+	return nil
+}
+
+// runHooks runs every hook of the event's stage in order. A failing hook only fails
+// the test if the input says so, otherwise it is just logged.
+func runHooks(ctx context.Context, in Input, event HookEvent) error {
+	for _, hook := range in.Hooks {
+		if hook.Stage != event.Stage {
+			continue
+		}
+
+		if err := hook.run(ctx, in, event); err != nil {
+			err = fmt.Errorf("%s hook %q failed for container %s: %w", hook.Stage, hook.Command, event.ContainerID, err)
+
+			if in.HookStrict {
+				return err
+			}
+
+			log.Print(err)
+		}
+	}
+
+	return nil
+}
+
+func (h Hook) run(ctx context.Context, in Input, event HookEvent) error {
+	stdin, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	env := event.environment()
+
+	if h.Exec {
+		out, err := execWithInput(ctx, in.Docker, event.ContainerID, []string{"sh", "-c", h.Command}, env, stdin)
+		fmt.Fprint(os.Stderr, out)
+		return err
+	}
+
+	var cmd *exec.Cmd
+
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.Command)
+	}
+
+	// hook output must not get mixed with the results
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// environment returns the event as GRACE_* environment variables
+func (e HookEvent) environment() []string {
+	env := []string{
+		"GRACE_STAGE=" + e.Stage,
+		"GRACE_CONTAINER_ID=" + e.ContainerID,
+	}
+
+	if e.Signal != "" {
+		env = append(env, "GRACE_SIGNAL="+e.Signal)
+	}
+
+	if e.SignalTime != nil {
+		env = append(env, "GRACE_SIGNAL_TIME="+e.SignalTime.Format(time.RFC3339Nano))
+	}
+
+	if e.Result != nil {
+		env = append(env,
+			"GRACE_TERMINATION="+e.Result.Termination,
+			"GRACE_EXIT_SIGNAL="+e.Result.Signal,
+			"GRACE_EXIT_STEP="+strconv.Itoa(e.Result.Step),
+			"GRACE_EXIT_CODE="+strconv.Itoa(e.Result.ExitCode),
+			"GRACE_DURATION="+strconv.FormatFloat(e.Result.Duration, 'f', 3, 64),
+		)
+	}
+
+	return env
+}
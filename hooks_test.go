@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCheckReportHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    Mode
+		hook    Hook
+		wantErr bool
+	}{
+		{name: "host hook", mode: Clone, hook: Hook{Stage: HookAfterReport, Command: "./notify.sh"}},
+		{name: "exec hook on existing containers", mode: Existing, hook: Hook{Stage: HookAfterReport, Command: "cat /tmp/state", Exec: true}},
+		{name: "exec hook at another stage", mode: FromImage, hook: Hook{Stage: HookAfterSignal, Command: "cat /tmp/state", Exec: true}},
+		{name: "exec hook on fresh containers", mode: FromImage, hook: Hook{Stage: HookAfterReport, Command: "cat /tmp/state", Exec: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkReportHooks(Input{Mode: tt.mode, Hooks: []Hook{tt.hook}})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkReportHooks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,1069 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	_ "crypto/sha256" // the digests of images
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types/container"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// The prefixes of images read without the Docker daemon, as skopeo names them
+const (
+	// PrefixOCI is an OCI image layout directory, optionally followed by the
+	// reference name of the image in the layout, as in "oci:DIR:TAG".
+	PrefixOCI = "oci:"
+
+	// PrefixArchive is a tarball written by docker save, holding a single image.
+	PrefixArchive = "docker-archive:"
+
+	// PrefixRegistry is an image reference pulled over the registry HTTP API.
+	PrefixRegistry = "docker://"
+)
+
+// The media types of Docker images, which registries serve along with OCI ones
+const (
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+const (
+	// maxManifestSize is how much of a manifest or an image configuration is read
+	maxManifestSize = 4 << 20
+
+	// maxLinks is how many symbolic links are followed when reading a file
+	maxLinks = 10
+
+	// registryTimeout is how long a request to a registry may take, without
+	// counting the download of layers
+	registryTimeout = 30 * time.Second
+
+	// whiteoutPrefix marks files deleted by a layer, and whiteoutOpaque directories
+	// whose content in lower layers is hidden
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// imageSource reads the configuration and the layers of an image, without the
+// Docker daemon
+type imageSource interface {
+	// config is the JSON configuration of the image
+	config() ([]byte, error)
+
+	// layers are the tarballs of the image, from the bottom to the top, possibly
+	// compressed
+	layers() []layer
+
+	close() error
+}
+
+// layer opens the tarball of a layer
+type layer func() (io.ReadCloser, error)
+
+// isDaemonless tells if an image is read without the Docker daemon
+func isDaemonless(image string) bool {
+	return strings.HasPrefix(image, PrefixOCI) || strings.HasPrefix(image, PrefixArchive) || strings.HasPrefix(image, PrefixRegistry)
+}
+
+// openImage opens an image with one of the daemonless prefixes
+func openImage(image string) (imageSource, error) {
+	switch {
+	case strings.HasPrefix(image, PrefixOCI):
+		return openOCI(strings.TrimPrefix(image, PrefixOCI))
+	case strings.HasPrefix(image, PrefixArchive):
+		return openArchive(strings.TrimPrefix(image, PrefixArchive))
+	case strings.HasPrefix(image, PrefixRegistry):
+		return openRegistry(strings.TrimPrefix(image, PrefixRegistry))
+	}
+
+	return nil, fmt.Errorf("image %s is not read without the daemon", image)
+}
+
+// lintDaemonless checks an image read without the Docker daemon
+func lintDaemonless(image string) ([]Finding, error) {
+	src, err := openImage(image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer src.close()
+
+	raw, err := src.config()
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the Docker image configuration is a superset of the OCI one, with the same
+	// field names as the container configuration
+	var cfg struct {
+		Config *container.Config `json:"config"`
+	}
+
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("reading the configuration of %s: %w", image, err)
+	}
+
+	if cfg.Config == nil {
+		return nil, fmt.Errorf("image %s has no configuration", image)
+	}
+
+	var tree *imageTree
+
+	return lintImage(image, cfg.Config, func(file string) ([]byte, error) {
+		// the layers are only indexed if there is a file to read
+		if tree == nil {
+			if tree, err = indexImage(src); err != nil {
+				return nil, err
+			}
+		}
+
+		return tree.readFile(file)
+	})
+}
+
+// imageTree is the file tree of an image, the way the overlay filesystem of a
+// container would show it, indexed once from the headers of its layers
+type imageTree struct {
+	layers  []layer
+	entries map[string]treeEntry
+}
+
+// treeEntry is a file of an image tree and the layer that holds it
+type treeEntry struct {
+	typeflag byte
+	layer    int
+
+	// link is the absolute path a symbolic link points to, or the file of the
+	// same layer a hard link names
+	link string
+}
+
+// indexImage reads the layers of an image, from the bottom to the top, into its
+// file tree
+func indexImage(src imageSource) (*imageTree, error) {
+	t := &imageTree{layers: src.layers(), entries: map[string]treeEntry{}}
+
+	for i := range t.layers {
+		if err := t.apply(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// apply adds the files of a layer to the tree. Whiteouts only hide the files of
+// the lower layers, so they are applied before the files of the layer itself.
+func (t *imageTree) apply(i int) error {
+	rc, err := t.layers[i]()
+
+	if err != nil {
+		return err
+	}
+
+	defer rc.Close()
+
+	r, err := decompress(rc)
+
+	if err != nil {
+		return err
+	}
+
+	var deleted, opaque []string
+
+	added := map[string]treeEntry{}
+	archive := tar.NewReader(r)
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + header.Name)
+		dir, base := path.Split(name)
+		dir = path.Clean(dir)
+
+		switch {
+		case base == whiteoutOpaque:
+			opaque = append(opaque, dir)
+
+		case strings.HasPrefix(base, whiteoutPrefix):
+			deleted = append(deleted, path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+
+		default:
+			entry := treeEntry{typeflag: header.Typeflag, layer: i}
+
+			switch header.Typeflag {
+			case tar.TypeSymlink:
+				entry.link = resolveLink(name, header.Linkname)
+			case tar.TypeLink:
+				entry.link = path.Clean("/" + header.Linkname)
+			}
+
+			added[name] = entry
+		}
+	}
+
+	// the rest of the layer is read for its digest to be checked
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+
+	for name := range t.entries {
+		if isHidden(name, deleted, opaque) {
+			delete(t.entries, name)
+		}
+	}
+
+	for name, entry := range added {
+		t.entries[name] = entry
+	}
+
+	return nil
+}
+
+// isHidden tells if a file of a lower layer is deleted by a whiteout, or is in an
+// opaque directory
+func isHidden(name string, deleted, opaque []string) bool {
+	for _, d := range deleted {
+		if name == d || isWithin(name, d) {
+			return true
+		}
+	}
+
+	for _, dir := range opaque {
+		if isWithin(name, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lookup finds the path a file of the tree really is at, following symbolic links,
+// including the ones among the directories of its path, as /bin often is. It
+// returns an empty path if there is no such file.
+func (t *imageTree) lookup(file string) (string, error) {
+	rest := splitPath(file)
+	current := "/"
+
+	for links := 0; len(rest) > 0; {
+		name := path.Join(current, rest[0])
+		rest = rest[1:]
+
+		entry, ok := t.entries[name]
+
+		switch {
+		case ok && entry.typeflag == tar.TypeSymlink:
+			if links++; links > maxLinks {
+				return "", fmt.Errorf("too many symbolic links for %s", file)
+			}
+
+			rest = append(splitPath(entry.link), rest...)
+			current = "/"
+
+		case !ok && len(rest) == 0:
+			return "", nil
+
+		default:
+			// layers may leave out the directories of a path
+			current = name
+		}
+	}
+
+	return current, nil
+}
+
+// splitPath is the names of the directories and the file of a path
+func splitPath(file string) []string {
+	return strings.Split(strings.TrimPrefix(path.Clean("/"+file), "/"), "/")
+}
+
+// readFile reads a regular file of the tree, and returns nil if there is no such
+// file
+func (t *imageTree) readFile(file string) ([]byte, error) {
+	name, err := t.lookup(file)
+
+	if err != nil || name == "" {
+		return nil, err
+	}
+
+	switch entry := t.entries[name]; entry.typeflag {
+	case tar.TypeReg:
+		return t.extract(entry.layer, name)
+	case tar.TypeLink:
+		return t.extract(entry.layer, entry.link)
+	}
+
+	return nil, nil
+}
+
+// extract reads a regular file out of a layer
+func (t *imageTree) extract(i int, file string) ([]byte, error) {
+	rc, err := t.layers[i]()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+
+	r, err := decompress(rc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	archive := tar.NewReader(r)
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if path.Clean("/"+header.Name) == file && header.Typeflag == tar.TypeReg {
+			return io.ReadAll(io.LimitReader(archive, maxScriptSize))
+		}
+	}
+}
+
+// isWithin tells if a path is inside a directory
+func isWithin(file, dir string) bool {
+	return dir == "/" || strings.HasPrefix(file, dir+"/")
+}
+
+// resolveLink is the absolute path a symbolic link points to
+func resolveLink(name, target string) string {
+	if path.IsAbs(target) {
+		return path.Clean(target)
+	}
+
+	return path.Join(path.Dir(name), target)
+}
+
+// decompress reads a layer tarball, which may be compressed with gzip
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, fmt.Errorf("layers compressed with zstd are not supported")
+	}
+
+	return buffered, nil
+}
+
+// verifyBlob checks that a blob read whole matches its digest
+func verifyBlob(d digest.Digest, raw []byte) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	if d.Algorithm().FromBytes(raw) != d {
+		return fmt.Errorf("blob %s does not match its digest", d)
+	}
+
+	return nil
+}
+
+// verifiedReader reads a blob, and fails at its end if it does not match its
+// digest
+type verifiedReader struct {
+	io.ReadCloser
+	digest   digest.Digest
+	verifier digest.Verifier
+}
+
+func newVerifiedReader(rc io.ReadCloser, d digest.Digest) (*verifiedReader, error) {
+	if err := d.Validate(); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return &verifiedReader{ReadCloser: rc, digest: d, verifier: d.Verifier()}, nil
+}
+
+func (r *verifiedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.verifier.Write(p[:n])
+
+	if err == io.EOF && !r.verifier.Verified() {
+		return n, fmt.Errorf("blob %s does not match its digest", r.digest)
+	}
+
+	return n, err
+}
+
+// resolveManifest fetches the manifest of an image, choosing the one of the
+// platform grace runs on from an index, and checks the digests of the manifests
+// that have one
+func resolveManifest(fetch func(ocispec.Descriptor) ([]byte, error), desc ocispec.Descriptor) (*ocispec.Manifest, error) {
+	for depth := 0; depth < 3; depth++ {
+		raw, err := fetch(desc)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if desc.Digest != "" {
+			if err := verifyBlob(desc.Digest, raw); err != nil {
+				return nil, err
+			}
+		}
+
+		// both manifests and indexes may omit their media type
+		var m struct {
+			MediaType string               `json:"mediaType"`
+			Manifests []ocispec.Descriptor `json:"manifests"`
+		}
+
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, fmt.Errorf("reading manifest %s: %w", desc.Digest, err)
+		}
+
+		if m.MediaType != ocispec.MediaTypeImageIndex && m.MediaType != mediaTypeDockerList && len(m.Manifests) == 0 {
+			var manifest ocispec.Manifest
+
+			if err := json.Unmarshal(raw, &manifest); err != nil {
+				return nil, fmt.Errorf("reading manifest %s: %w", desc.Digest, err)
+			}
+
+			return &manifest, nil
+		}
+
+		if desc, err = choosePlatform(m.Manifests); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("too many nested indexes for manifest %s", desc.Digest)
+}
+
+// choosePlatform picks the manifest of the platform grace runs on, falling back to
+// linux/amd64
+func choosePlatform(manifests []ocispec.Descriptor) (ocispec.Descriptor, error) {
+	for _, arch := range []string{runtime.GOARCH, "amd64"} {
+		for _, m := range manifests {
+			if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == arch {
+				return m, nil
+			}
+		}
+	}
+
+	if len(manifests) == 1 {
+		return manifests[0], nil
+	}
+
+	return ocispec.Descriptor{}, fmt.Errorf("no manifest for linux/%s among %d manifests", runtime.GOARCH, len(manifests))
+}
+
+// ociSource reads an image from an OCI image layout directory
+type ociSource struct {
+	dir      string
+	manifest *ocispec.Manifest
+}
+
+func openOCI(spec string) (*ociSource, error) {
+	dir, tag := spec, ""
+
+	// the reference name follows the last colon, unless it is part of the path
+	if i := strings.LastIndex(spec, ":"); i >= 0 && !strings.Contains(spec[i:], "/") {
+		dir, tag = spec[:i], spec[i+1:]
+	}
+
+	s := &ociSource{dir: dir}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "index.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var index ocispec.Index
+
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Join(dir, "index.json"), err)
+	}
+
+	var candidates []ocispec.Descriptor
+
+	for _, m := range index.Manifests {
+		if tag == "" || m.Annotations[ocispec.AnnotationRefName] == tag {
+			candidates = append(candidates, m)
+		}
+	}
+
+	switch {
+	case len(candidates) == 0 && tag != "":
+		return nil, fmt.Errorf("no image named %s in %s", tag, dir)
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("no image in %s", dir)
+	case len(candidates) > 1 && tag == "":
+		return nil, fmt.Errorf("%s holds %d images, name one with oci:%s:NAME", dir, len(candidates), dir)
+	}
+
+	if s.manifest, err = resolveManifest(s.blob, candidates[0]); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *ociSource) blobPath(d digest.Digest) (string, error) {
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(s.dir, "blobs", d.Algorithm().String(), d.Encoded()), nil
+}
+
+func (s *ociSource) blob(desc ocispec.Descriptor) ([]byte, error) {
+	p, err := s.blobPath(desc.Digest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return io.ReadAll(io.LimitReader(f, maxManifestSize))
+}
+
+func (s *ociSource) config() ([]byte, error) {
+	raw, err := s.blob(s.manifest.Config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return raw, verifyBlob(s.manifest.Config.Digest, raw)
+}
+
+func (s *ociSource) layers() []layer {
+	var layers []layer
+
+	for _, desc := range s.manifest.Layers {
+		desc := desc
+
+		layers = append(layers, func() (io.ReadCloser, error) {
+			p, err := s.blobPath(desc.Digest)
+
+			if err != nil {
+				return nil, err
+			}
+
+			f, err := os.Open(p)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return newVerifiedReader(f, desc.Digest)
+		})
+	}
+
+	return layers
+}
+
+func (s *ociSource) close() error {
+	return nil
+}
+
+// archiveSource reads an image from a tarball written by docker save, which is
+// scanned again for each entry read from it
+type archiveSource struct {
+	file         string
+	raw          []byte
+	layerEntries []string
+
+	// diffIDs are the digests of the uncompressed layers, which docker save writes
+	diffIDs []digest.Digest
+}
+
+func openArchive(file string) (*archiveSource, error) {
+	s := &archiveSource{file: file}
+
+	rc, err := s.open("manifest.json")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+
+	var manifest []struct {
+		Config string
+		Layers []string
+	}
+
+	if err := json.NewDecoder(io.LimitReader(rc, maxManifestSize)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading the manifest of %s: %w", file, err)
+	}
+
+	if len(manifest) != 1 {
+		return nil, fmt.Errorf("%s holds %d images rather than one", file, len(manifest))
+	}
+
+	s.layerEntries = manifest[0].Layers
+
+	if s.raw, err = s.readConfig(manifest[0].Config); err != nil {
+		return nil, err
+	}
+
+	var cfg ocispec.Image
+
+	if err := json.Unmarshal(s.raw, &cfg); err != nil {
+		return nil, fmt.Errorf("reading the configuration of %s: %w", file, err)
+	}
+
+	if len(cfg.RootFS.DiffIDs) == len(s.layerEntries) {
+		s.diffIDs = cfg.RootFS.DiffIDs
+	}
+
+	return s, nil
+}
+
+// readConfig reads the image configuration, and checks its digest, which docker
+// save names it after, as <digest>.json or blobs/sha256/<digest>
+func (s *archiveSource) readConfig(name string) ([]byte, error) {
+	rc, err := s.open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(rc, maxManifestSize))
+
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := digest.Canonical
+
+	if dir := path.Dir(name); path.Dir(dir) == "blobs" {
+		algorithm = digest.Algorithm(path.Base(dir))
+	}
+
+	d := digest.NewDigestFromEncoded(algorithm, strings.TrimSuffix(path.Base(name), ".json"))
+
+	// tools other than docker save may name it otherwise
+	if d.Validate() != nil {
+		return raw, nil
+	}
+
+	return raw, verifyBlob(d, raw)
+}
+
+// open finds an entry of the tarball, following symbolic links, which docker save
+// uses for layers shared by several images
+func (s *archiveSource) open(name string) (io.ReadCloser, error) {
+	name = path.Clean(name)
+
+	for links := 0; links < maxLinks; links++ {
+		f, err := os.Open(s.file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		archive := tar.NewReader(f)
+
+		var header *tar.Header
+
+		for {
+			if header, err = archive.Next(); err != nil || path.Clean(header.Name) == name {
+				break
+			}
+		}
+
+		if err == io.EOF {
+			f.Close()
+			return nil, fmt.Errorf("no %s in %s", name, s.file)
+		}
+
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeSymlink {
+			return struct {
+				io.Reader
+				io.Closer
+			}{archive, f}, nil
+		}
+
+		f.Close()
+		name = path.Join(path.Dir(name), header.Linkname)
+	}
+
+	return nil, fmt.Errorf("too many symbolic links for %s in %s", name, s.file)
+}
+
+func (s *archiveSource) config() ([]byte, error) {
+	return s.raw, nil
+}
+
+func (s *archiveSource) layers() []layer {
+	var layers []layer
+
+	for i, name := range s.layerEntries {
+		i, name := i, name
+
+		layers = append(layers, func() (io.ReadCloser, error) {
+			rc, err := s.open(name)
+
+			if err != nil || s.diffIDs == nil {
+				return rc, err
+			}
+
+			return newVerifiedReader(rc, s.diffIDs[i])
+		})
+	}
+
+	return layers
+}
+
+func (s *archiveSource) close() error {
+	return nil
+}
+
+// registrySource reads an image over the registry HTTP API, anonymously. Layers are
+// downloaded once, into temporary files.
+type registrySource struct {
+	base     string
+	repo     string
+	token    string
+	client   *http.Client
+	manifest *ocispec.Manifest
+
+	// cache holds the temporary files of the layers, in dir
+	cache map[digest.Digest]string
+	dir   string
+}
+
+func openRegistry(ref string) (*registrySource, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+
+	if err != nil {
+		return nil, err
+	}
+
+	named = reference.TagNameOnly(named)
+
+	domain := reference.Domain(named)
+
+	if domain == "docker.io" {
+		domain = "registry-1.docker.io"
+	}
+
+	// local registries are usually served over plain HTTP
+	scheme := "https"
+	host := strings.Split(domain, ":")[0]
+
+	if host == "localhost" || host == "127.0.0.1" || strings.HasPrefix(domain, "[::1]") {
+		scheme = "http"
+	}
+
+	s := &registrySource{
+		base:   scheme + "://" + domain + "/v2/" + reference.Path(named),
+		repo:   reference.Path(named),
+		client: &http.Client{},
+		cache:  map[digest.Digest]string{},
+	}
+
+	var (
+		tag  string
+		desc ocispec.Descriptor
+	)
+
+	// a manifest fetched by tag has no digest to check it against
+	switch r := named.(type) {
+	case reference.Canonical:
+		desc.Digest = r.Digest()
+	case reference.Tagged:
+		tag = r.Tag()
+	}
+
+	fetch := func(desc ocispec.Descriptor) ([]byte, error) {
+		target := string(desc.Digest)
+
+		if target == "" {
+			target = tag
+		}
+
+		return s.fetch("/manifests/"+target, ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex, mediaTypeDockerManifest, mediaTypeDockerList)
+	}
+
+	if s.manifest, err = resolveManifest(fetch, desc); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// get requests a path of the repository, authenticating anonymously if the
+// registry asks for a token
+func (s *registrySource) get(ctx context.Context, p string, accept ...string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+p, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+
+		resp, err := s.client.Do(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+
+			if s.token, err = s.authenticate(challenge); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("registry answered %s for %s", resp.Status, s.base+p)
+		}
+
+		return resp, nil
+	}
+}
+
+// fetch reads a small document of the repository, such as a manifest
+func (s *registrySource) fetch(p string, accept ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+	defer cancel()
+
+	resp, err := s.get(ctx, p, accept...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+}
+
+// authenticate obtains an anonymous token for pulling the repository from the
+// realm of a Bearer challenge
+func (s *registrySource) authenticate(challenge string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("registry requires unsupported authentication %q", challenge)
+	}
+
+	params := map[string]string{}
+
+	for _, param := range strings.Split(challenge[len("bearer "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	realm, err := url.Parse(params["realm"])
+
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("registry challenge %q has no realm", challenge)
+	}
+
+	query := realm.Query()
+
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+
+	scope := params["scope"]
+
+	if scope == "" {
+		scope = "repository:" + s.repo + ":pull"
+	}
+
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	client := &http.Client{Timeout: registryTimeout}
+
+	resp, err := client.Get(realm.String())
+
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token server answered %s for %s", resp.Status, realm.Redacted())
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token); err != nil {
+		return "", err
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	if token.Token == "" {
+		return "", fmt.Errorf("token server gave no token for %s", s.repo)
+	}
+
+	return token.Token, nil
+}
+
+func (s *registrySource) config() ([]byte, error) {
+	if err := s.manifest.Config.Digest.Validate(); err != nil {
+		return nil, err
+	}
+
+	raw, err := s.fetch("/blobs/" + string(s.manifest.Config.Digest))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return raw, verifyBlob(s.manifest.Config.Digest, raw)
+}
+
+func (s *registrySource) layers() []layer {
+	var layers []layer
+
+	for _, desc := range s.manifest.Layers {
+		d := desc.Digest
+
+		layers = append(layers, func() (io.ReadCloser, error) {
+			if err := s.download(d); err != nil {
+				return nil, err
+			}
+
+			return os.Open(s.cache[d])
+		})
+	}
+
+	return layers
+}
+
+// download saves a layer to a temporary file, unless it already was, and checks
+// its digest
+func (s *registrySource) download(d digest.Digest) error {
+	if _, ok := s.cache[d]; ok {
+		return nil
+	}
+
+	if err := d.Validate(); err != nil {
+		return err
+	}
+
+	if s.dir == "" {
+		dir, err := os.MkdirTemp("", "grace-layers-")
+
+		if err != nil {
+			return err
+		}
+
+		s.dir = dir
+	}
+
+	resp, err := s.get(context.Background(), "/blobs/"+string(d))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	p := filepath.Join(s.dir, d.Encoded())
+
+	f, err := os.Create(p)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	verifier := d.Verifier()
+
+	if _, err := io.Copy(io.MultiWriter(f, verifier), resp.Body); err != nil {
+		return err
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("layer %s does not match its digest", d)
+	}
+
+	s.cache[d] = p
+
+	return nil
+}
+
+func (s *registrySource) close() error {
+	if s.dir == "" {
+		return nil
+	}
+
+	return os.RemoveAll(s.dir)
+}
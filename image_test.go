@@ -0,0 +1,428 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testFile is an entry of a layer tarball built by a test
+type testFile struct {
+	name     string
+	typeflag byte
+	content  string
+	link     string
+}
+
+func makeTar(t *testing.T, files ...testFile) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	archive := tar.NewWriter(&buf)
+
+	for _, f := range files {
+		typeflag := f.typeflag
+
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+
+		header := &tar.Header{Name: f.name, Typeflag: typeflag, Linkname: f.link, Mode: 0755, Size: int64(len(f.content))}
+
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := archive.Write([]byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func mustJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// memorySource is an image whose layers are held in memory
+type memorySource struct {
+	blobs [][]byte
+}
+
+func (s memorySource) config() ([]byte, error) {
+	return nil, nil
+}
+
+func (s memorySource) layers() []layer {
+	var layers []layer
+
+	for _, blob := range s.blobs {
+		blob := blob
+
+		layers = append(layers, func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(blob)), nil
+		})
+	}
+
+	return layers
+}
+
+func (s memorySource) close() error {
+	return nil
+}
+
+func TestImageTree(t *testing.T) {
+	lower := makeTar(t,
+		testFile{name: "bin", typeflag: tar.TypeSymlink, link: "usr/bin"},
+		testFile{name: "usr/bin/sh", content: "sh"},
+		testFile{name: "usr/bin/app", content: "lower app"},
+		testFile{name: "usr/bin/entry", typeflag: tar.TypeSymlink, link: "../../srv/entry.sh"},
+		testFile{name: "usr/local/bin/run", typeflag: tar.TypeLink, link: "usr/bin/app"},
+		testFile{name: "srv/entry.sh", content: "entry"},
+		testFile{name: "etc/removed", content: "removed"},
+		testFile{name: "etc/conf", content: "old conf"},
+		testFile{name: "opt/data/", typeflag: tar.TypeDir},
+		testFile{name: "opt/data/old", content: "old"},
+		testFile{name: "loop1", typeflag: tar.TypeSymlink, link: "loop2"},
+		testFile{name: "loop2", typeflag: tar.TypeSymlink, link: "/loop1"},
+	)
+
+	upper := gzipped(t, makeTar(t,
+		testFile{name: "usr/bin/app", content: "upper app"},
+		testFile{name: "etc/.wh.removed"},
+		testFile{name: "etc/conf", content: "new conf"},
+		testFile{name: "etc/.wh.conf"},
+		testFile{name: "opt/data/.wh..wh..opq"},
+		testFile{name: "opt/data/new", content: "new"},
+	))
+
+	tree, err := indexImage(memorySource{blobs: [][]byte{lower, upper}})
+
+	if err != nil {
+		t.Fatalf("indexImage() error = %v", err)
+	}
+
+	tests := []struct {
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{file: "/usr/bin/sh", want: "sh"},
+		{file: "usr/bin/sh", want: "sh"},
+		{file: "/bin/app", want: "upper app"},
+		{file: "/usr/bin/entry", want: "entry"},
+		{file: "/usr/local/bin/run", want: "lower app"},
+		{file: "/etc/removed"},
+		{file: "/etc/conf", want: "new conf"},
+		{file: "/opt/data/old"},
+		{file: "/opt/data/new", want: "new"},
+		{file: "/opt/data"},
+		{file: "/bin"},
+		{file: "/missing"},
+		{file: "/bin/missing"},
+		{file: "/loop1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := tree.readFile(tt.file)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readFile(%q) error = %v, wantErr %v", tt.file, err, tt.wantErr)
+			}
+
+			if string(got) != tt.want || (got == nil) != (tt.want == "") {
+				t.Errorf("readFile(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+// testImage is an image whose entrypoint script misses an exec, as its
+// configuration and its uncompressed layer
+func testImage(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	layer := makeTar(t,
+		testFile{name: "bin", typeflag: tar.TypeSymlink, link: "usr/bin"},
+		testFile{name: "usr/bin/entrypoint.sh", content: "#!/bin/sh\n./app\n"},
+	)
+
+	config := mustJSON(t, map[string]interface{}{
+		"architecture": runtime.GOARCH,
+		"os":           "linux",
+		"config":       map[string]interface{}{"Entrypoint": []string{"entrypoint.sh"}, "Env": []string{"PATH=/bin"}},
+		"rootfs":       ocispec.RootFS{Type: "layers", DiffIDs: []digest.Digest{digest.FromBytes(layer)}},
+	})
+
+	return config, layer
+}
+
+// hasMissingExec tells if the entrypoint script of the test image was analyzed
+func hasMissingExec(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Rule == RuleMissingExec && strings.HasSuffix(f.Target, " /bin/entrypoint.sh:2") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestLintOCI(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		corrupt string
+		wantErr bool
+	}{
+		{name: "named", image: ":v1"},
+		{name: "only image", image: ""},
+		{name: "unknown name", image: ":v2", wantErr: true},
+		{name: "corrupt manifest", image: ":v1", corrupt: "manifest", wantErr: true},
+		{name: "corrupt config", image: ":v1", corrupt: "config", wantErr: true},
+		{name: "corrupt layer", image: ":v1", corrupt: "layer", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			writeBlob := func(kind, mediaType string, data []byte) ocispec.Descriptor {
+				d := digest.FromBytes(data)
+
+				if kind == tt.corrupt {
+					data = append(data, '\n')
+				}
+
+				p := filepath.Join(dir, "blobs", "sha256", d.Encoded())
+
+				if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(p, data, 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+			}
+
+			config, layer := testImage(t)
+
+			manifest := writeBlob("manifest", ocispec.MediaTypeImageManifest, mustJSON(t, ocispec.Manifest{
+				Config: writeBlob("config", ocispec.MediaTypeImageConfig, config),
+				Layers: []ocispec.Descriptor{writeBlob("layer", ocispec.MediaTypeImageLayerGzip, gzipped(t, layer))},
+			}))
+
+			manifest.Annotations = map[string]string{ocispec.AnnotationRefName: "v1"}
+
+			if err := os.WriteFile(filepath.Join(dir, "index.json"), mustJSON(t, ocispec.Index{Manifests: []ocispec.Descriptor{manifest}}), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			findings, err := lintDaemonless(PrefixOCI + dir + tt.image)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lintDaemonless() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !hasMissingExec(findings) {
+				t.Errorf("lintDaemonless() = %+v, want the entrypoint script analyzed", findings)
+			}
+		})
+	}
+}
+
+func TestLintArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt string
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "corrupt config", corrupt: "config", wantErr: true},
+		{name: "corrupt layer", corrupt: "layer", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, layer := testImage(t)
+			configName := digest.FromBytes(config).Encoded() + ".json"
+
+			switch tt.corrupt {
+			case "config":
+				config = append(config, '\n')
+			case "layer":
+				layer = makeTar(t, testFile{name: "usr/bin/entrypoint.sh", content: "#!/bin/sh\nexec ./app\n"})
+			}
+
+			// docker save links layers shared by several images
+			archive := makeTar(t,
+				testFile{name: "manifest.json", content: string(mustJSON(t, []map[string]interface{}{{"Config": configName, "Layers": []string{"abc/layer.tar"}}}))},
+				testFile{name: configName, content: string(config)},
+				testFile{name: "def/layer.tar", content: string(layer)},
+				testFile{name: "abc/layer.tar", typeflag: tar.TypeSymlink, link: "../def/layer.tar"},
+			)
+
+			file := filepath.Join(t.TempDir(), "image.tar")
+
+			if err := os.WriteFile(file, archive, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			findings, err := lintDaemonless(PrefixArchive + file)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lintDaemonless() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !hasMissingExec(findings) {
+				t.Errorf("lintDaemonless() = %+v, want the entrypoint script analyzed", findings)
+			}
+		})
+	}
+}
+
+func TestLintRegistry(t *testing.T) {
+	config, layer := testImage(t)
+	layer = gzipped(t, layer)
+
+	configDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))}
+	layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+
+	manifest := mustJSON(t, ocispec.Manifest{Config: configDesc, Layers: []ocispec.Descriptor{layerDesc}})
+	manifestDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+		Platform:  &ocispec.Platform{OS: "linux", Architecture: runtime.GOARCH},
+	}
+
+	index := mustJSON(t, ocispec.Index{Manifests: []ocispec.Descriptor{manifestDesc}})
+
+	tests := []struct {
+		name    string
+		ref     string
+		corrupt string
+		wantErr bool
+	}{
+		{name: "tag", ref: ":v1"},
+		{name: "digest", ref: "@" + digest.FromBytes(index).String()},
+		{name: "unknown tag", ref: ":v2", wantErr: true},
+		{name: "corrupt index", ref: "@" + digest.FromBytes(index).String(), corrupt: "index", wantErr: true},
+		{name: "corrupt manifest", ref: ":v1", corrupt: "manifest", wantErr: true},
+		{name: "corrupt config", ref: ":v1", corrupt: "config", wantErr: true},
+		{name: "corrupt layer", ref: ":v1", corrupt: "layer", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobs := map[string][]byte{
+				"/v2/grace/app/manifests/v1":                                  index,
+				"/v2/grace/app/manifests/" + digest.FromBytes(index).String(): index,
+				"/v2/grace/app/manifests/" + manifestDesc.Digest.String():     manifest,
+				"/v2/grace/app/blobs/" + configDesc.Digest.String():           config,
+				"/v2/grace/app/blobs/" + layerDesc.Digest.String():            layer,
+			}
+
+			corrupted := map[string]string{
+				"index":    "/v2/grace/app/manifests/" + digest.FromBytes(index).String(),
+				"manifest": "/v2/grace/app/manifests/" + manifestDesc.Digest.String(),
+				"config":   "/v2/grace/app/blobs/" + configDesc.Digest.String(),
+				"layer":    "/v2/grace/app/blobs/" + layerDesc.Digest.String(),
+			}
+
+			if p, ok := corrupted[tt.corrupt]; ok {
+				blobs[p] = append(append([]byte{}, blobs[p]...), '\n')
+			}
+
+			var server *httptest.Server
+
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/token" {
+					if r.URL.Query().Get("scope") != "repository:grace/app:pull" || r.URL.Query().Get("service") != "test" {
+						http.Error(w, "bad scope", http.StatusBadRequest)
+						return
+					}
+
+					_, _ = w.Write([]byte(`{"token":"secret"}`))
+					return
+				}
+
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test"`)
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				data, ok := blobs[r.URL.Path]
+
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+
+				_, _ = w.Write(data)
+			}))
+
+			defer server.Close()
+
+			ref := strings.TrimPrefix(server.URL, "http://") + "/grace/app" + tt.ref
+
+			findings, err := lintDaemonless(PrefixRegistry + ref)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lintDaemonless() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !hasMissingExec(findings) {
+				t.Errorf("lintDaemonless() = %+v, want the entrypoint script analyzed", findings)
+			}
+		})
+	}
+}
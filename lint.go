@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/urfave/cli/v2"
+)
+
+// The severities of lint findings
+const (
+	// SeverityError is a configuration that prevents graceful termination.
+	SeverityError = "error"
+
+	// SeverityWarning is a configuration that commonly breaks graceful termination.
+	SeverityWarning = "warning"
+
+	// SeverityInfo is a configuration worth knowing about when testing termination.
+	SeverityInfo = "info"
+)
+
+// The lint rules
+const (
+	RuleShellForm  = "shell-form"
+	RuleStopSignal = "stop-signal"
+	RuleNoInit     = "no-init"
+	RuleTTY        = "tty"
+	RuleStdin      = "stdin"
+	RuleHealth     = "healthcheck"
+)
+
+// usualStopSignals are the signals applications commonly shut down on
+var usualStopSignals = []string{
+	"SIGTERM", "SIGINT", "SIGQUIT", "SIGHUP", "SIGUSR1", "SIGUSR2", "SIGWINCH", "SIGPWR", "SIGRTMIN+3",
+}
+
+// initProcesses are the programs that forward signals to their children and reap
+// zombies when running as PID 1
+var initProcesses = []string{
+	"tini", "tini-static", "dumb-init", "docker-init", "catatonit", "init", "s6-svscan", "runsvdir", "supervisord",
+}
+
+// Finding is a problem found in the configuration of an image, without running it
+type Finding struct {
+	Target   string
+	Severity string
+	Rule     string
+	Message  string
+}
+
+var lintCommand = &cli.Command{
+	Name:      "lint",
+	Usage:     "checks the configuration of images for termination problems, without running them",
+	UsageText: "grace lint IMAGE [IMAGE ...]",
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			cli.ShowCommandHelpAndExit(c, "lint", 0)
+		}
+
+		docker, err := client.NewClientWithOpts(client.FromEnv)
+
+		if err != nil {
+			return err
+		}
+
+		return runLint(docker, c.Args().Slice(), os.Stdout)
+	},
+}
+
+func runLint(docker *client.Client, images []string, writer io.Writer) error {
+	ctx := context.Background()
+
+	var findings []Finding
+
+	for _, image := range images {
+		var f []Finding
+		var err error
+
+		if isDaemonless(image) {
+			f, err = lintDaemonless(image)
+		} else {
+			f, err = lintDaemon(ctx, docker, image)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		findings = append(findings, f...)
+	}
+
+	writeFindings(writer, findings)
+
+	return nil
+}
+
+// lintDaemon checks an image known to the daemon, pulling it if needed
+func lintDaemon(ctx context.Context, docker *client.Client, image string) ([]Finding, error) {
+	if err := pullImage(ctx, docker, image); err != nil {
+		return nil, err
+	}
+
+	inspect, _, err := docker.ImageInspectWithRaw(ctx, image)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if inspect.Config == nil {
+		return nil, fmt.Errorf("image %s has no configuration", image)
+	}
+
+	read, remove := containerFiles(ctx, docker, image)
+	defer remove()
+
+	return lintImage(image, inspect.Config, read)
+}
+
+// lintImage checks the configuration of an image, and its entrypoint script if any
+func lintImage(image string, config *container.Config, read fileReader) ([]Finding, error) {
+	findings := lintConfig(image, config)
+
+	script, content, err := findScript(config, read)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if content != nil && isShellScript(content) {
+		findings = append(findings, analyzeScript(image+" "+script, content, config.StopSignal)...)
+	}
+
+	return findings, nil
+}
+
+// lintConfig checks a container configuration, as found in an image
+func lintConfig(target string, config *container.Config) []Finding {
+	var findings []Finding
+
+	add := func(severity, rule, format string, args ...interface{}) {
+		findings = append(findings, Finding{
+			Target:   target,
+			Severity: severity,
+			Rule:     rule,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	command := append(append([]string{}, config.Entrypoint...), config.Cmd...)
+
+	shellForm := isShellForm(config.Entrypoint) || (len(config.Entrypoint) == 0 && isShellForm(config.Cmd))
+
+	if shellForm {
+		add(SeverityError, RuleShellForm, "the command runs in shell form, so the shell is PID 1 and does not forward the stop signal to `%s`; use the exec form", command[2])
+	}
+
+	switch signal := normalizeSignal(config.StopSignal); {
+	case config.StopSignal == "":
+		add(SeverityInfo, RuleStopSignal, "no stop signal is set, so containers are stopped with SIGTERM")
+	case signal == killSignal:
+		add(SeverityError, RuleStopSignal, "the stop signal is SIGKILL, which cannot be handled")
+	case !contains(usualStopSignals, signal):
+		add(SeverityWarning, RuleStopSignal, "the stop signal %s is unusual, make sure the application handles it", config.StopSignal)
+	}
+
+	// the shell is not an init process either, but that was already reported
+	if len(command) > 0 && !shellForm && !isInit(command[0]) {
+		add(SeverityWarning, RuleNoInit, "PID 1 is %s rather than an init process, so it must handle signals and reap zombies itself; consider tini or docker run --init", command[0])
+	}
+
+	if config.Tty {
+		add(SeverityWarning, RuleTTY, "a TTY is allocated, which makes shells interactive and changes how they handle signals")
+	}
+
+	if config.OpenStdin {
+		add(SeverityWarning, RuleStdin, "the standard input is kept open, so applications waiting for it to close never exit on their own")
+	}
+
+	if config.Healthcheck == nil || len(config.Healthcheck.Test) == 0 || config.Healthcheck.Test[0] == "NONE" {
+		add(SeverityInfo, RuleHealth, "no healthcheck is set, so orchestrators and grace cannot tell when containers are ready")
+	}
+
+	return findings
+}
+
+// isShellForm tells if a command was given in shell form, which Docker wraps in
+// "/bin/sh -c", unless the shell replaces itself with the command
+func isShellForm(command []string) bool {
+	if len(command) < 3 || command[1] != "-c" {
+		return false
+	}
+
+	if shell := path.Base(command[0]); shell != "sh" && shell != "bash" && shell != "ash" && shell != "dash" {
+		return false
+	}
+
+	return !strings.HasPrefix(strings.TrimSpace(command[2]), "exec ")
+}
+
+// isInit tells if a program is a known init process
+func isInit(program string) bool {
+	return contains(initProcesses, path.Base(program))
+}
+
+func writeFindings(writer io.Writer, findings []Finding) {
+	table := newTable(writer)
+
+	var rows [][]string
+
+	for _, f := range findings {
+		rows = append(rows, []string{f.Target, f.Severity, f.Rule, f.Message})
+	}
+
+	table.SetHeader([]string{
+		"TARGET", "SEVERITY", "RULE", "FINDING",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runMatrix stops one fresh container per target and signal, so we can see which
+// signals each target actually handles gracefully
+func runMatrix(in Input, writer io.Writer) error {
+	var data []Output
+
+	for _, target := range in.Containers {
+		for _, signal := range in.Signals {
+			run := in
+			run.Signal = signal
+
+			out, err := runOnce(context.Background(), run, target)
+
+			if err != nil {
+				return err
+			}
+
+			data = append(data, out)
+		}
+	}
+
+	writeMatrix(writer, data)
+
+	return afterReport(context.Background(), in, data)
+}
+
+func writeMatrix(writer io.Writer, data []Output) {
+	table := newTable(writer)
+
+	var rows [][]string
+
+	for _, out := range data {
+		var note string
+
+		if out.FirstSignal == out.StopSignal {
+			note = "stop signal"
+		}
+
+		rows = append(rows, []string{
+			out.Target,
+			out.FirstSignal,
+			out.Termination.String(),
+			out.Signal,
+			strconv.FormatInt(int64(out.ExitCode), 10),
+			fmt.Sprintf("%3ss/%ss", strconv.FormatInt(int64(out.StopDuration/time.Second), 10), strconv.FormatInt(int64(out.Timeout/time.Second), 10)),
+			note,
+		})
+	}
+
+	table.SetHeader([]string{
+		"TARGET", "SIGNAL", "TERMINATION", "ENDED BY", "EXIT CODE", "DURATION", "NOTE",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, mismatch := range findMismatches(data) {
+		fmt.Fprintf(writer, "\n%s", mismatch)
+	}
+
+	writeResend(writer, data)
+	writePreStop(writer, data)
+	writeSockets(writer, data)
+	writeVerification(writer, data)
+	writeProfiles(writer, data)
+	writeStaleFiles(writer, data)
+	writeDiagnostics(writer, data)
+	writeRecommendations(writer, data)
+}
+
+// findMismatches reports the targets whose configured stop signal was tested and is
+// not handled gracefully, while some other signal is
+func findMismatches(data []Output) []string {
+	var targets []string
+
+	stopSignals := map[string]string{}
+	tested := map[string][]string{}
+	graceful := map[string][]string{}
+
+	for _, out := range data {
+		if _, ok := stopSignals[out.Target]; !ok {
+			targets = append(targets, out.Target)
+		}
+
+		stopSignals[out.Target] = out.StopSignal
+		tested[out.Target] = append(tested[out.Target], out.FirstSignal)
+
+		if out.Termination == GracefulSuccess {
+			graceful[out.Target] = append(graceful[out.Target], out.FirstSignal)
+		}
+	}
+
+	var mismatches []string
+
+	for _, target := range targets {
+		signals := graceful[target]
+
+		if len(signals) == 0 || !contains(tested[target], stopSignals[target]) || contains(signals, stopSignals[target]) {
+			continue
+		}
+
+		mismatches = append(mismatches, fmt.Sprintf(
+			"MISMATCH %s: stop signal %s is not handled gracefully, but %s is; consider setting STOPSIGNAL %s\n",
+			target, stopSignals[target], strings.Join(signals, ", "), signals[0],
+		))
+	}
+
+	return mismatches
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
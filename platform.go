@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Platform describes how a platform stops containers, which grace emulates instead
+// of the container daemon's defaults
+type Platform struct {
+	Name string
+
+	// Signal is sent regardless of the container's configuration, unless empty, in
+	// which case the container's stop signal is honored
+	Signal string
+
+	// Timeout is the time the container is given before it is killed, unless zero,
+	// in which case the container's stop timeout is honored
+	Timeout time.Duration
+}
+
+// platforms are the built-in platform profiles, with their default semantics
+var platforms = map[string]Platform{
+	// docker stop honors the container's stop signal and stop timeout
+	"docker": {Name: "docker"},
+
+	// the kubelet honors the image's stop signal, and its grace period includes the
+	// preStop hook, see Pod
+	"kubernetes": {Name: "kubernetes", Timeout: defaultGracePeriod},
+
+	// the ECS agent stops containers with docker stop and a 30s default timeout
+	"ecs": {Name: "ecs", Timeout: 30 * time.Second},
+
+	// Cloud Run sends SIGTERM and kills the instance 10s later
+	"cloudrun": {Name: "cloudrun", Signal: "SIGTERM", Timeout: 10 * time.Second},
+
+	// Heroku sends SIGTERM to all processes of the dyno and kills them 30s later
+	"heroku": {Name: "heroku", Signal: "SIGTERM", Timeout: 30 * time.Second},
+
+	// Fly.io sends SIGINT and kills the machine 5s later, unless told otherwise
+	"fly": {Name: "fly", Signal: "SIGINT", Timeout: 5 * time.Second},
+}
+
+// platformNames lists the built-in platform profiles
+func platformNames() []string {
+	var names []string
+
+	for name := range platforms {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// loadPlatform returns the profile of the named platform, adjusted by its
+// configuration file if one is given: a fly.toml for fly, an ECS task definition
+// for ecs or a Pod spec for kubernetes. The Pod to emulate is also returned for
+// kubernetes, as its grace period includes the preStop hook.
+func loadPlatform(name, config, container string) (*Platform, *Pod, error) {
+	platform, ok := platforms[strings.ToLower(name)]
+
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown platform %q, must be one of %s", name, strings.Join(platformNames(), ", "))
+	}
+
+	if config == "" {
+		if platform.Name == "kubernetes" {
+			return &platform, &Pod{GracePeriod: platform.Timeout}, nil
+		}
+
+		return &platform, nil, nil
+	}
+
+	var err error
+
+	switch platform.Name {
+	case "fly":
+		err = platform.loadFly(config)
+
+	case "ecs":
+		err = platform.loadECS(config, container)
+
+	case "kubernetes":
+		pod, err := loadPod(config, container)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		platform.Timeout = pod.GracePeriod
+
+		return &platform, pod, nil
+
+	default:
+		err = fmt.Errorf("platform %s has no configuration file", platform.Name)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &platform, nil, nil
+}
+
+// loadFly reads kill_signal and kill_timeout from a fly.toml file
+func (p *Platform) loadFly(path string) error {
+	var config struct {
+		KillSignal  string      `toml:"kill_signal"`
+		KillTimeout interface{} `toml:"kill_timeout"`
+	}
+
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return err
+	}
+
+	if config.KillSignal != "" {
+		p.Signal = normalizeSignal(config.KillSignal)
+	}
+
+	// older files give the timeout in seconds, newer ones as a duration
+	switch timeout := config.KillTimeout.(type) {
+	case nil:
+	case int64:
+		p.Timeout = time.Duration(timeout) * time.Second
+	case string:
+		d, err := time.ParseDuration(timeout)
+
+		if err != nil {
+			return fmt.Errorf("invalid kill_timeout in %s: %w", path, err)
+		}
+
+		p.Timeout = d
+	default:
+		return fmt.Errorf("invalid kill_timeout in %s: %v", path, timeout)
+	}
+
+	return nil
+}
+
+// ecsContainerDefinitions is the part of an ECS task definition we care about
+type ecsContainerDefinitions struct {
+	ContainerDefinitions []struct {
+		Name        string `json:"name"`
+		StopTimeout *int   `json:"stopTimeout"`
+	} `json:"containerDefinitions"`
+}
+
+// loadECS reads the stopTimeout of a container from an ECS task definition, either
+// as registered or as returned by describe-task-definition
+func (p *Platform) loadECS(path, container string) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	var task struct {
+		ecsContainerDefinitions
+		TaskDefinition *ecsContainerDefinitions `json:"taskDefinition"`
+	}
+
+	if err := json.Unmarshal(data, &task); err != nil {
+		return err
+	}
+
+	definitions := task.ecsContainerDefinitions
+
+	if task.TaskDefinition != nil {
+		definitions = *task.TaskDefinition
+	}
+
+	for _, definition := range definitions.ContainerDefinitions {
+		if container != "" && definition.Name != container {
+			continue
+		}
+
+		if definition.StopTimeout != nil {
+			p.Timeout = time.Duration(*definition.StopTimeout) * time.Second
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("no container definition named %q in %s", container, path)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultGracePeriod is Kubernetes' default terminationGracePeriodSeconds
+	defaultGracePeriod = 30 * time.Second
+
+	// minimumGracePeriod is what the kubelet gives the container after a preStop
+	// hook that used up the whole grace period
+	minimumGracePeriod = 2 * time.Second
+)
+
+// Pod is what grace emulates of a Kubernetes container's termination: its preStop
+// hook and the grace period shared by the hook and the application
+type Pod struct {
+	GracePeriod time.Duration
+	PreStop     *PodHandler
+
+	// Ports maps the names of the container ports to their numbers, for httpGet
+	// hooks that refer to ports by name
+	Ports map[string]int
+}
+
+// PodHandler is a Kubernetes lifecycle handler, only one of its fields is set
+type PodHandler struct {
+	Exec *struct {
+		Command []string `yaml:"command"`
+	} `yaml:"exec"`
+
+	HTTPGet *struct {
+		Path        string `yaml:"path"`
+		Port        string `yaml:"port"`
+		Host        string `yaml:"host"`
+		Scheme      string `yaml:"scheme"`
+		HTTPHeaders []struct {
+			Name  string `yaml:"name"`
+			Value string `yaml:"value"`
+		} `yaml:"httpHeaders"`
+	} `yaml:"httpGet"`
+
+	Sleep *struct {
+		Seconds int64 `yaml:"seconds"`
+	} `yaml:"sleep"`
+}
+
+func (h PodHandler) String() string {
+	switch {
+	case h.Exec != nil:
+		return "exec"
+	case h.HTTPGet != nil:
+		return "httpGet"
+	case h.Sleep != nil:
+		return "sleep"
+	}
+
+	return "none"
+}
+
+// PreStop is the outcome of a preStop hook
+type PreStop struct {
+	Handler  string
+	Duration time.Duration
+
+	// Error is why the hook failed, if it did; like the kubelet, we carry on with
+	// the stop signal anyway
+	Error string
+
+	// GracePeriod is the budget shared by the hook and the application, and
+	// Remaining what was left of it for the application
+	GracePeriod time.Duration
+	Remaining   time.Duration
+}
+
+// PodSpec is the subset of a Kubernetes Pod spec that matters for termination
+type PodSpec struct {
+	TerminationGracePeriodSeconds *int64 `yaml:"terminationGracePeriodSeconds"`
+
+	Containers []struct {
+		Name  string `yaml:"name"`
+		Ports []struct {
+			Name          string `yaml:"name"`
+			ContainerPort int    `yaml:"containerPort"`
+		} `yaml:"ports"`
+		Lifecycle *struct {
+			PreStop *PodHandler `yaml:"preStop"`
+		} `yaml:"lifecycle"`
+	} `yaml:"containers"`
+}
+
+// podManifest is either a Pod or a workload with a Pod template, such as a
+// Deployment or a StatefulSet
+type podManifest struct {
+	Spec struct {
+		PodSpec  `yaml:",inline"`
+		Template *struct {
+			Spec PodSpec `yaml:"spec"`
+		} `yaml:"template"`
+	} `yaml:"spec"`
+}
+
+// loadPod reads the first Pod spec of a YAML file that has the named container,
+// or any container if no name is given, and returns what grace emulates of it
+func loadPod(path, name string) (*Pod, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return parsePod(f, path, name)
+}
+
+// parsePod reads the documents of a YAML manifest until one has a Pod spec with the
+// named container, or any container if no name is given
+func parsePod(r io.Reader, path, name string) (*Pod, error) {
+	decoder := yaml.NewDecoder(r)
+
+	// found tells if there was a Pod spec, though without the named container
+	found := false
+
+	for {
+		var manifest podManifest
+
+		if err := decoder.Decode(&manifest); err != nil {
+			switch {
+			case errors.Is(err, io.EOF) && found:
+				return nil, fmt.Errorf("no container named %s in the pod specs of %s", name, path)
+			case errors.Is(err, io.EOF):
+				return nil, fmt.Errorf("no pod spec found in %s", path)
+			}
+
+			return nil, err
+		}
+
+		spec := manifest.Spec.PodSpec
+
+		if manifest.Spec.Template != nil {
+			spec = manifest.Spec.Template.Spec
+		}
+
+		if len(spec.Containers) == 0 {
+			continue
+		}
+
+		pod := &Pod{
+			GracePeriod: defaultGracePeriod,
+			Ports:       map[string]int{},
+		}
+
+		if spec.TerminationGracePeriodSeconds != nil {
+			pod.GracePeriod = time.Duration(*spec.TerminationGracePeriodSeconds) * time.Second
+		}
+
+		for _, container := range spec.Containers {
+			if name != "" && container.Name != name {
+				continue
+			}
+
+			for _, port := range container.Ports {
+				pod.Ports[port.Name] = port.ContainerPort
+			}
+
+			if container.Lifecycle != nil {
+				pod.PreStop = container.Lifecycle.PreStop
+			}
+
+			return pod, nil
+		}
+
+		found = true
+	}
+}
+
+// runPreStop runs the preStop hook like the kubelet does: it may take up to the
+// whole grace period, and whatever is left of it goes to the application, but never
+// less than the kubelet's minimum. A timeout given to grace, as by sweep or
+// --what-if, replaces the grace period of the Pod.
+func runPreStop(ctx context.Context, in Input, json types.ContainerJSON) PreStop {
+	pod := in.Pod
+
+	preStop := PreStop{GracePeriod: pod.GracePeriod}
+
+	if in.Timeout > 0 {
+		preStop.GracePeriod = in.Timeout
+	}
+
+	if pod.PreStop != nil {
+		preStop.Handler = pod.PreStop.String()
+
+		hookCtx, cancel := context.WithTimeout(ctx, preStop.GracePeriod)
+		defer cancel()
+
+		start := time.Now()
+
+		if err := pod.runHandler(hookCtx, in, json); err != nil {
+			preStop.Error = err.Error()
+		}
+
+		preStop.Duration = time.Since(start)
+	}
+
+	preStop.Remaining = preStop.GracePeriod - preStop.Duration
+
+	if preStop.Remaining < minimumGracePeriod {
+		preStop.Remaining = minimumGracePeriod
+	}
+
+	return preStop
+}
+
+func (pod *Pod) runHandler(ctx context.Context, in Input, json types.ContainerJSON) error {
+	h := pod.PreStop
+
+	switch {
+	case h.Exec != nil:
+		_, err := execInContainer(ctx, in.Docker, json.ID, h.Exec.Command)
+		return err
+
+	case h.HTTPGet != nil:
+		return pod.httpGet(ctx, json)
+
+	case h.Sleep != nil:
+		select {
+		case <-time.After(time.Duration(h.Sleep.Seconds) * time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return fmt.Errorf("preStop hook has no handler")
+}
+
+func (pod *Pod) httpGet(ctx context.Context, json types.ContainerJSON) error {
+	get := pod.PreStop.HTTPGet
+
+	host := get.Host
+
+	if host == "" {
+		host = containerIP(json)
+	}
+
+	port := get.Port
+
+	if n, ok := pod.Ports[port]; ok {
+		port = strconv.Itoa(n)
+	}
+
+	scheme := strings.ToLower(get.Scheme)
+
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	path := get.Path
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+net.JoinHostPort(host, port)+path, nil)
+
+	if err != nil {
+		return err
+	}
+
+	for _, header := range get.HTTPHeaders {
+		req.Header.Add(header.Name, header.Value)
+	}
+
+	// like the kubelet, we don't verify certificates
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("preStop hook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func writePreStop(writer io.Writer, data []Output) {
+	var rows [][]string
+
+	for _, out := range data {
+		if out.PreStop == nil {
+			continue
+		}
+
+		result := "ok"
+
+		if out.PreStop.Error != "" {
+			result = out.PreStop.Error
+		}
+
+		rows = append(rows, []string{
+			out.ShortID,
+			out.PreStop.Handler,
+			out.PreStop.Duration.Round(time.Millisecond).String(),
+			out.PreStop.Remaining.Round(time.Millisecond).String() + "/" + out.PreStop.GracePeriod.String(),
+			result,
+		})
+	}
+
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintln(writer)
+
+	table := newTable(writer)
+
+	table.SetHeader([]string{
+		"ID", "PRESTOP", "HOOK DURATION", "APP BUDGET", "RESULT",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+}
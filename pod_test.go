@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePod(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  ports:
+    - port: 80
+---
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+        - name: sidecar
+---
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      terminationGracePeriodSeconds: 60
+      containers:
+        - name: app
+          ports:
+            - name: http
+              containerPort: 8080
+          lifecycle:
+            preStop:
+              sleep:
+                seconds: 5
+`
+
+	tests := []struct {
+		name        string
+		in          string
+		container   string
+		wantGrace   time.Duration
+		wantPreStop string
+		wantErr     bool
+	}{
+		{name: "first container", in: manifest, wantGrace: defaultGracePeriod, wantPreStop: "none"},
+		{name: "container in a later document", in: manifest, container: "app", wantGrace: time.Minute, wantPreStop: "sleep"},
+		{name: "unknown container", in: manifest, container: "db", wantErr: true},
+		{name: "no pod spec", in: "apiVersion: v1\nkind: Service\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, err := parsePod(strings.NewReader(tt.in), "pod.yaml", tt.container)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			preStop := "none"
+
+			if pod.PreStop != nil {
+				preStop = pod.PreStop.String()
+			}
+
+			if pod.GracePeriod != tt.wantGrace || preStop != tt.wantPreStop {
+				t.Errorf("parsePod() = %s, %s, want %s, %s", pod.GracePeriod, preStop, tt.wantGrace, tt.wantPreStop)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procRoot is where the host's proc filesystem is expected to be mounted
+const procRoot = "/proc"
+
+// Process is a single process running within a container
+type Process struct {
+	PID     int
+	State   string
+	WChan   string
+	Threads int
+	Command string
+}
+
+// Socket is a single TCP socket as listed in /proc/net/tcp and /proc/net/tcp6
+type Socket struct {
+	Local  string
+	Remote string
+	State  string
+	Inode  uint64
+}
+
+// tcpStates maps the hexadecimal state codes used by /proc/net/tcp to their names
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// hasHostProc reports whether the host /proc entry of the given process is visible
+// to grace, which is only true when grace runs on the same host (and PID namespace)
+// as the container daemon
+func hasHostProc(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	_, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	return err == nil
+}
+
+// readProcess reads the state, wait channel, thread count and command line of a
+// process from the host /proc
+func readProcess(pid int) (Process, error) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+
+	if err != nil {
+		return Process{}, err
+	}
+
+	// the command name may contain spaces and parenthesis, so we look for the last one
+	end := strings.LastIndexByte(string(stat), ')')
+
+	if end < 0 {
+		return Process{}, fmt.Errorf("malformed stat for process %d", pid)
+	}
+
+	fields := strings.Fields(string(stat[end+1:]))
+
+	if len(fields) < 18 {
+		return Process{}, fmt.Errorf("malformed stat for process %d", pid)
+	}
+
+	threads, _ := strconv.Atoi(fields[17])
+
+	wchan, _ := os.ReadFile(filepath.Join(dir, "wchan"))
+	cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+
+	return Process{
+		PID:     pid,
+		State:   fields[0],
+		WChan:   strings.TrimSpace(string(wchan)),
+		Threads: threads,
+		Command: strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " ")),
+	}, nil
+}
+
+// readSockets reads the TCP sockets of the network namespace the given process
+// belongs to from the host /proc
+func readSockets(pid int) ([]Socket, error) {
+	var sockets []Socket
+
+	for _, name := range []string{"tcp", "tcp6"} {
+		f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "net", name))
+
+		if err != nil {
+			return nil, err
+		}
+
+		sockets = append(sockets, parseSockets(f)...)
+		f.Close()
+	}
+
+	return sockets, nil
+}
+
+// parseSockets parses the contents of /proc/net/tcp or /proc/net/tcp6, skipping
+// header lines and any other lines it does not understand
+func parseSockets(r io.Reader) []Socket {
+	var sockets []Socket
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 10 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+
+		local, err := parseSocketAddress(fields[1])
+
+		if err != nil {
+			continue
+		}
+
+		remote, err := parseSocketAddress(fields[2])
+
+		if err != nil {
+			continue
+		}
+
+		state, ok := tcpStates[strings.ToUpper(fields[3])]
+
+		if !ok {
+			state = fields[3]
+		}
+
+		inode, _ := strconv.ParseUint(fields[9], 10, 64)
+
+		sockets = append(sockets, Socket{
+			Local:  local,
+			Remote: remote,
+			State:  state,
+			Inode:  inode,
+		})
+	}
+
+	return sockets
+}
+
+// parseSocketAddress converts an address such as "0100007F:1F90" into "127.0.0.1:8080".
+// The kernel prints addresses as a sequence of 32 bit words in host byte order, which
+// we assume to be little endian.
+func parseSocketAddress(s string) (string, error) {
+	parts := strings.Split(s, ":")
+
+	if len(parts) != 2 {
+		return "", fmt.Errorf("malformed socket address %q", s)
+	}
+
+	raw, err := hex.DecodeString(parts[0])
+
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", fmt.Errorf("malformed socket address %q", s)
+	}
+
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+
+	if err != nil {
+		return "", fmt.Errorf("malformed socket address %q", s)
+	}
+
+	return net.JoinHostPort(net.IP(raw).String(), strconv.FormatUint(port, 10)), nil
+}
+
+// readCaughtSignals reads the mask of the signals a process installed a handler for
+// from /proc/<pid>/status, where the bit of signal n is n-1
+func readCaughtSignals(pid int) (uint64, error) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer f.Close()
+
+	return parseCaughtSignals(f)
+}
+
+func parseCaughtSignals(r io.Reader) (uint64, error) {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		if value := strings.TrimPrefix(scanner.Text(), "SigCgt:"); value != scanner.Text() {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("no SigCgt in status")
+}
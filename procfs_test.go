@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSocketAddress(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0100007F:1F90", want: "127.0.0.1:8080"},
+		{in: "00000000:0050", want: "0.0.0.0:80"},
+		{in: "00000000000000000000000001000000:1F90", want: "[::1]:8080"},
+		{in: "0000000000000000FFFF00000100007F:01BB", want: "127.0.0.1:443"},
+		{in: "0100007F", wantErr: true},
+		{in: "0100007F:1F90:00", wantErr: true},
+		{in: "ZZ00007F:1F90", wantErr: true},
+		{in: "01007F:1F90", wantErr: true},
+		{in: "0100007F:FFFFF", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSocketAddress(tt.in)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSocketAddress(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("parseSocketAddress(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSockets(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Socket
+	}{
+		{
+			name: "tcp",
+			in: `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 12345 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:1F90 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 12346 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:1F90 0100007F:C351 08 00000000:00000000 00:00000000 00000000     0        0 0 1 0000000000000000 20 4 30 10 -1
+`,
+			want: []Socket{
+				{Local: "0.0.0.0:8080", Remote: "0.0.0.0:0", State: "LISTEN", Inode: 12345},
+				{Local: "127.0.0.1:8080", Remote: "127.0.0.1:50000", State: "ESTABLISHED", Inode: 12346},
+				{Local: "127.0.0.1:8080", Remote: "127.0.0.1:50001", State: "CLOSE_WAIT", Inode: 0},
+			},
+		},
+		{
+			name: "tcp6",
+			in: `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:0050 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 999 1 0000000000000000 100 0 0 10 0
+`,
+			want: []Socket{
+				{Local: "[::]:80", Remote: "[::]:0", State: "LISTEN", Inode: 999},
+			},
+		},
+		{
+			name: "unknown state and malformed lines",
+			in: `   0: 0100007F:1F90 0100007F:C350 FF 00000000:00000000 00:00000000 00000000     0        0 7 1
+   1: garbage 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 8 1
+   2: too short
+`,
+			want: []Socket{
+				{Local: "127.0.0.1:8080", Remote: "127.0.0.1:50000", State: "FF", Inode: 7},
+			},
+		},
+		{
+			name: "empty",
+			in:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseSockets(strings.NewReader(tt.in))
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSockets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCaughtSignals(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "SIGTERM and SIGINT", in: "Name:\tnode\nSigIgn:\t0000000000001000\nSigCgt:\t0000000000004002\n", want: 1<<14 | 1<<1},
+		{name: "none", in: "SigCgt:\t0000000000000000\n", want: 0},
+		{name: "missing", in: "Name:\tsleep\n", wantErr: true},
+		{name: "malformed", in: "SigCgt:\tzz\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCaughtSignals(strings.NewReader(tt.in))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCaughtSignals() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("parseCaughtSignals() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
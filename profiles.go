@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	// profileNone disables the profile of a target, even if one was given for every
+	// target
+	profileNone = "none"
+
+	// profileAuto detects the profile of a target from its image
+	profileAuto = "auto"
+)
+
+// Profile is what a clean shutdown of a stateful service looks like, which is
+// precise enough to be checked in its logs and on disk
+type Profile struct {
+	Name string
+
+	// Images are the repositories the profile is detected from
+	Images []string
+
+	// Signal is the stop signal the service shuts down cleanly on
+	Signal string
+
+	// Marker is logged once the service shut down cleanly
+	Marker *regexp.Regexp
+
+	// Files are asserted once the container exited, like --verify-file, after
+	// expanding the variables of the container's environment, which default to Env
+	Files []string
+	Env   map[string]string
+}
+
+var profiles = map[string]Profile{
+	"postgres": {
+		Name:   "postgres",
+		Images: []string{"postgres", "postgresql", "postgis", "timescaledb"},
+		Signal: "SIGINT",
+		Marker: regexp.MustCompile(`database system is shut down`),
+		Files:  []string{"!${PGDATA}/postmaster.pid"},
+		Env:    map[string]string{"PGDATA": "/var/lib/postgresql/data"},
+	},
+	"mysql": {
+		Name:   "mysql",
+		Images: []string{"mysql", "mariadb", "percona", "percona-server"},
+		Signal: "SIGTERM",
+		Marker: regexp.MustCompile(`(mysqld|mariadbd): Shutdown complete`),
+		Files:  []string{"!/var/run/mysqld/mysqld.pid"},
+	},
+	"redis": {
+		Name:   "redis",
+		Images: []string{"redis", "redis-stack-server", "valkey"},
+		Signal: "SIGTERM",
+		Marker: regexp.MustCompile(`is now ready to exit, bye bye`),
+		// a snapshot interrupted while being saved on shutdown is left behind
+		Files: []string{"!/data/temp-1.rdb"},
+	},
+	"mongodb": {
+		Name:   "mongodb",
+		Images: []string{"mongo", "mongodb-community-server"},
+		Signal: "SIGTERM",
+		Marker: regexp.MustCompile(`Now exiting`),
+		// the lock file is emptied on a clean shutdown
+		Files: []string{`/data/db/mongod.lock=\A\z`},
+	},
+}
+
+func profileNames() []string {
+	var names []string
+
+	for name := range profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ProfileCheck is the outcome of checking a stop against the profile of a service
+type ProfileCheck struct {
+	Profile string
+
+	// Detected tells if the profile was recognized from the image rather than given
+	Detected bool
+
+	// Signal is the stop signal the profile expects
+	Signal string
+
+	// Marker tells if the service logged that it shut down cleanly
+	Marker bool
+
+	// Failures are the checks that did not hold, the shutdown is clean if there are
+	// none
+	Failures []string
+}
+
+// parseProfiles parses profile selections such as "postgres", for every target, or
+// "db=postgres" for a single one
+func parseProfiles(values []string) (map[string]string, error) {
+	selected := map[string]string{}
+
+	for _, value := range values {
+		target, name := "", value
+
+		if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+			target, name = parts[0], parts[1]
+		}
+
+		if _, ok := profiles[name]; !ok && name != profileNone && name != profileAuto {
+			return nil, fmt.Errorf("unknown profile %q, must be one of %s, %s or %s", name, strings.Join(profileNames(), ", "), profileAuto, profileNone)
+		}
+
+		selected[target] = name
+	}
+
+	return selected, nil
+}
+
+// selectProfile returns the profile given for a target, or the one of its image
+// if it is to be detected, and tells if it was detected. Targets without a profile
+// given have none.
+func selectProfile(in Input, target, image string) (*Profile, bool) {
+	name, ok := in.Profiles[target]
+
+	if !ok {
+		name = in.Profiles[""]
+	}
+
+	if name != profileAuto {
+		if p, ok := profiles[name]; ok {
+			return &p, false
+		}
+
+		return nil, false
+	}
+
+	repository := imageRepository(image)
+
+	for _, name := range profileNames() {
+		if p := profiles[name]; contains(p.Images, repository) {
+			return &p, true
+		}
+	}
+
+	return nil, false
+}
+
+// imageRepository is the repository of an image, without its registry, namespace,
+// tag or digest
+func imageRepository(image string) string {
+	return strings.SplitN(strings.SplitN(path.Base(image), "@", 2)[0], ":", 2)[0]
+}
+
+// checkProfile checks that an exited container was sent the signal of the
+// profile, looks for the marker of a clean shutdown in what it logged after the
+// signal, and asserts the files of the profile
+func checkProfile(ctx context.Context, in Input, json types.ContainerJSON, p *Profile, signal string, signaled time.Time) *ProfileCheck {
+	check := &ProfileCheck{Profile: p.Name, Signal: p.Signal}
+
+	if normalizeSignal(signal) != p.Signal {
+		check.Failures = append(check.Failures, fmt.Sprintf("%s was sent first, but %s only shuts down cleanly on %s", signal, p.Name, p.Signal))
+	}
+
+	logs, err := readLogs(ctx, in.Docker, json.ID, signaled)
+
+	switch {
+	case err != nil:
+		check.Failures = append(check.Failures, err.Error())
+	case p.Marker.MatchString(logs):
+		check.Marker = true
+	default:
+		check.Failures = append(check.Failures, fmt.Sprintf("%q was not logged after the signal", p.Marker.String()))
+	}
+
+	env := map[string]string{}
+
+	for k, v := range p.Env {
+		env[k] = v
+	}
+
+	for _, v := range json.Config.Env {
+		if kv := strings.SplitN(v, "=", 2); len(kv) == 2 && kv[1] != "" {
+			env[kv[0]] = kv[1]
+		}
+	}
+
+	var files []string
+
+	for _, file := range p.Files {
+		files = append(files, os.Expand(file, func(k string) string { return env[k] }))
+	}
+
+	assertions, err := parseFileAssertions(files)
+
+	if err != nil {
+		check.Failures = append(check.Failures, err.Error())
+	}
+
+	for _, a := range assertions {
+		if err := a.check(ctx, in, json.ID); err != nil {
+			check.Failures = append(check.Failures, err.Error())
+		}
+	}
+
+	return check
+}
+
+func writeProfiles(writer io.Writer, data []Output) {
+	var rows [][]string
+
+	for _, out := range data {
+		check := out.Profile
+
+		if check == nil {
+			continue
+		}
+
+		profile := check.Profile
+
+		if check.Detected {
+			profile += " (detected)"
+		}
+
+		signal := out.FirstSignal
+
+		if normalizeSignal(signal) != check.Signal {
+			signal += ", expected " + check.Signal
+		}
+
+		marker := "missing"
+
+		if check.Marker {
+			marker = "logged"
+		}
+
+		result := "clean"
+
+		if len(check.Failures) > 0 {
+			result = "unclean"
+		}
+
+		rows = append(rows, []string{out.ShortID, profile, signal, marker, result})
+	}
+
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintln(writer)
+
+	table := newTable(writer)
+
+	table.SetHeader([]string{
+		"ID", "PROFILE", "SIGNAL", "MARKER", "SHUTDOWN",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, out := range data {
+		if out.Profile == nil {
+			continue
+		}
+
+		for _, failure := range out.Profile.Failures {
+			fmt.Fprintf(writer, "\nUNCLEAN %s: %s\n", out.ShortID, failure)
+		}
+	}
+}
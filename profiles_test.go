@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "none given", want: map[string]string{}},
+		{name: "every target", in: []string{"postgres"}, want: map[string]string{"": "postgres"}},
+		{name: "auto", in: []string{"auto"}, want: map[string]string{"": "auto"}},
+		{
+			name: "single targets",
+			in:   []string{"auto", "db=postgres", "cache=none"},
+			want: map[string]string{"": "auto", "db": "postgres", "cache": "none"},
+		},
+		{name: "unknown profile", in: []string{"db=oracle"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProfiles(tt.in)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProfiles(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProfiles(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectProfile(t *testing.T) {
+	tests := []struct {
+		name         string
+		profiles     map[string]string
+		target       string
+		image        string
+		want         string
+		wantDetected bool
+	}{
+		{name: "not given", profiles: map[string]string{}, target: "db", image: "postgres:14"},
+		{name: "given", profiles: map[string]string{"db": "postgres"}, target: "db", image: "myapp", want: "postgres"},
+		{name: "given for every target", profiles: map[string]string{"": "redis"}, target: "cache", image: "myapp", want: "redis"},
+		{name: "given for another target", profiles: map[string]string{"db": "postgres"}, target: "cache", image: "redis"},
+		{name: "detected", profiles: map[string]string{"": "auto"}, target: "db", image: "docker.io/library/postgres:14-alpine", want: "postgres", wantDetected: true},
+		{name: "detected by digest", profiles: map[string]string{"": "auto"}, target: "db", image: "bitnami/mariadb@sha256:0123", want: "mysql", wantDetected: true},
+		{name: "not detected", profiles: map[string]string{"": "auto"}, target: "web", image: "nginx:1.21"},
+		{name: "turned off", profiles: map[string]string{"": "auto", "db": "none"}, target: "db", image: "postgres"},
+		{name: "given over detected", profiles: map[string]string{"": "auto", "db": "mysql"}, target: "db", image: "postgres", want: "mysql"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, detected := selectProfile(Input{Profiles: tt.profiles}, tt.target, tt.image)
+
+			var got string
+
+			if p != nil {
+				got = p.Name
+			}
+
+			if got != tt.want || detected != tt.wantDetected {
+				t.Errorf("selectProfile(%q, %q) = %q, %v, want %q, %v", tt.target, tt.image, got, detected, tt.want, tt.wantDetected)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// Mode is how grace gets hold of the containers it stops
+type Mode int
+
+const (
+	// Existing stops the given running containers themselves.
+	Existing Mode = iota
+
+	// Clone stops fresh copies of the given running containers, created with the same
+	// configuration, leaving the original containers untouched.
+	Clone
+
+	// FromImage stops fresh containers run from the given images.
+	FromImage
+)
+
+// CloneVolumes is what fresh copies of containers do with the volumes and bind
+// mounts the original containers write to
+type CloneVolumes string
+
+const (
+	// VolumesRefuse refuses to copy containers with writable volumes, since two
+	// instances writing the same data may corrupt it
+	VolumesRefuse CloneVolumes = "refuse"
+
+	// VolumesFresh gives the copies empty anonymous volumes at the same paths.
+	VolumesFresh CloneVolumes = "fresh"
+
+	// VolumesShared mounts the volumes of the originals into their copies.
+	VolumesShared CloneVolumes = "shared"
+)
+
+// fresh tells if the mode creates a new container for every run, which is required
+// by the features that stop the same target more than once
+func (m Mode) fresh() bool {
+	return m != Existing
+}
+
+// provision returns the container to stop for the given target, creating and
+// starting it when the mode requires a fresh container
+func provision(ctx context.Context, in Input, target string) (string, error) {
+	if !in.Mode.fresh() {
+		return target, nil
+	}
+
+	c, err := create(ctx, in, target)
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := waitStartup(ctx, in, c); err != nil {
+		cleanup(ctx, in, c)
+		return "", err
+	}
+
+	return c, nil
+}
+
+// create creates and starts a fresh container for the given target, without waiting
+// for it to start up
+func create(ctx context.Context, in Input, target string) (string, error) {
+	if !in.Mode.fresh() {
+		return "", fmt.Errorf("cannot create a fresh container for %s without clone or image", target)
+	}
+
+	config, hostConfig, err := targetConfig(ctx, in, target)
+
+	if err != nil {
+		return "", err
+	}
+
+	return start(ctx, in, config, hostConfig)
+}
+
+// targetConfig returns the configuration of a fresh container for the given target:
+// the one of the image for images, and a copy of the container's otherwise
+func targetConfig(ctx context.Context, in Input, target string) (*container.Config, *container.HostConfig, error) {
+	if in.Mode != FromImage {
+		return cloneConfig(ctx, in.Docker, target, in.CloneVolumes)
+	}
+
+	if err := pullImage(ctx, in.Docker, target); err != nil {
+		return nil, nil, err
+	}
+
+	return &container.Config{Image: target}, &container.HostConfig{}, nil
+}
+
+// cloneConfig returns the configuration of a container, adapted to create a copy of
+// it alongside the original, with the given policy for its writable volumes
+func cloneConfig(ctx context.Context, docker *client.Client, c string, volumes CloneVolumes) (*container.Config, *container.HostConfig, error) {
+	json, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := json.Config
+	hostConfig := json.HostConfig
+
+	// let the daemon pick a new hostname, and avoid conflicting with the host
+	// ports published by the original container
+	config.Hostname = ""
+	hostConfig.PortBindings = nil
+
+	// we remove the clone ourselves after inspecting how it terminated
+	hostConfig.AutoRemove = false
+
+	if volumes == VolumesShared {
+		return config, hostConfig, nil
+	}
+
+	shared, err := unshareVolumes(ctx, docker, config, hostConfig)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(shared) > 0 && volumes != VolumesFresh {
+		return nil, nil, fmt.Errorf("container %s writes to volumes or bind mounts a copy would share (%s), use --clone-volumes %s or %s", json.ID[:12], strings.Join(shared, ", "), VolumesFresh, VolumesShared)
+	}
+
+	return config, hostConfig, nil
+}
+
+// unshareVolumes replaces the writable bind mounts, named volumes and volumes of
+// other containers of a configuration with anonymous volumes at the same paths,
+// returning these paths. Read-only ones are kept, since they cannot be corrupted.
+func unshareVolumes(ctx context.Context, docker *client.Client, config *container.Config, hostConfig *container.HostConfig) ([]string, error) {
+	var shared []string
+
+	var binds []string
+
+	for _, bind := range hostConfig.Binds {
+		parts := strings.Split(bind, ":")
+
+		if len(parts) < 2 || readOnly(parts[2:]) {
+			binds = append(binds, bind)
+			continue
+		}
+
+		shared = append(shared, parts[1])
+	}
+
+	var mounts []mount.Mount
+
+	for _, m := range hostConfig.Mounts {
+		// volumes without a source are anonymous, the copy gets its own
+		if m.ReadOnly || (m.Type != mount.TypeBind && m.Type != mount.TypeVolume) || m.Source == "" {
+			mounts = append(mounts, m)
+			continue
+		}
+
+		shared = append(shared, m.Target)
+	}
+
+	var volumesFrom []string
+
+	for _, from := range hostConfig.VolumesFrom {
+		parts := strings.Split(from, ":")
+
+		if readOnly(parts[1:]) {
+			volumesFrom = append(volumesFrom, from)
+			continue
+		}
+
+		json, err := docker.ContainerInspect(ctx, parts[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range json.Mounts {
+			if m.RW {
+				shared = append(shared, m.Destination)
+			}
+		}
+	}
+
+	hostConfig.Binds = binds
+	hostConfig.Mounts = mounts
+	hostConfig.VolumesFrom = volumesFrom
+
+	if len(shared) > 0 && config.Volumes == nil {
+		config.Volumes = map[string]struct{}{}
+	}
+
+	for _, path := range shared {
+		config.Volumes[path] = struct{}{}
+	}
+
+	return shared, nil
+}
+
+// readOnly tells if the options of a bind or of volumes from another container,
+// such as "ro,z", make it read-only
+func readOnly(options []string) bool {
+	for _, option := range options {
+		for _, o := range strings.Split(option, ",") {
+			if o == "ro" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// start creates and starts a fresh container with the given configuration, under
+// the resource pressure of the input
+func start(ctx context.Context, in Input, config *container.Config, hostConfig *container.HostConfig) (string, error) {
+	in.Stress.apply(hostConfig)
+
+	created, err := in.Docker.ContainerCreate(ctx, config, hostConfig, nil, nil, "")
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := in.Docker.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+		cleanup(ctx, in, created.ID)
+		return "", err
+	}
+
+	if err := runHooks(ctx, in, HookEvent{Stage: HookBeforeReady, ContainerID: created.ID}); err != nil {
+		cleanup(ctx, in, created.ID)
+		return "", err
+	}
+
+	return created.ID, nil
+}
+
+// waitStartup gives a fresh container time to install its signal handlers and waits
+// for it to be ready, if we know how to tell, failing if it exits in the meantime
+func waitStartup(ctx context.Context, in Input, c string) error {
+	time.Sleep(in.Startup)
+
+	json, err := in.Docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return err
+	}
+
+	if in.Readiness.configured() || json.State.Health != nil {
+		_, err := waitReady(ctx, in, c)
+		return err
+	}
+
+	if !json.State.Running {
+		return fmt.Errorf("container %s exited during startup with code %d", json.ID[:12], json.State.ExitCode)
+	}
+
+	return nil
+}
+
+// cleanup removes a container created by provision, along with its anonymous volumes
+func cleanup(ctx context.Context, in Input, c string) {
+	if !in.Mode.fresh() {
+		return
+	}
+
+	_ = in.Docker.ContainerRemove(ctx, c, types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	})
+}
+
+// pullImage pulls an image unless it is already present
+func pullImage(ctx context.Context, docker *client.Client, image string) error {
+	_, _, err := docker.ImageInspectWithRaw(ctx, image)
+
+	if err == nil || !errdefs.IsNotFound(err) {
+		return err
+	}
+
+	progress, err := docker.ImagePull(ctx, image, types.ImagePullOptions{})
+
+	if err != nil {
+		return err
+	}
+
+	defer progress.Close()
+
+	_, err = io.Copy(io.Discard, progress)
+	return err
+}
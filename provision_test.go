@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestUnshareVolumes(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostConfig  container.HostConfig
+		wantShared  []string
+		wantBinds   []string
+		wantMounts  []mount.Mount
+		wantVolumes map[string]struct{}
+	}{
+		{
+			name: "read-only",
+			hostConfig: container.HostConfig{
+				Binds:  []string{"/etc/app:/etc/app:ro", "config:/config:ro,z"},
+				Mounts: []mount.Mount{{Type: mount.TypeBind, Source: "/certs", Target: "/certs", ReadOnly: true}},
+			},
+			wantBinds:  []string{"/etc/app:/etc/app:ro", "config:/config:ro,z"},
+			wantMounts: []mount.Mount{{Type: mount.TypeBind, Source: "/certs", Target: "/certs", ReadOnly: true}},
+		},
+		{
+			name: "writable",
+			hostConfig: container.HostConfig{
+				Binds: []string{"/srv/data:/data", "pgdata:/var/lib/postgresql/data:rw", "/etc/app:/etc/app:ro"},
+				Mounts: []mount.Mount{
+					{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+					{Type: mount.TypeVolume, Target: "/anonymous"},
+					{Type: mount.TypeTmpfs, Target: "/tmp"},
+				},
+			},
+			wantShared: []string{"/data", "/var/lib/postgresql/data", "/cache"},
+			wantBinds:  []string{"/etc/app:/etc/app:ro"},
+			wantMounts: []mount.Mount{
+				{Type: mount.TypeVolume, Target: "/anonymous"},
+				{Type: mount.TypeTmpfs, Target: "/tmp"},
+			},
+			wantVolumes: map[string]struct{}{"/data": {}, "/var/lib/postgresql/data": {}, "/cache": {}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &container.Config{}
+			hostConfig := tt.hostConfig
+
+			shared, err := unshareVolumes(context.Background(), nil, config, &hostConfig)
+
+			if err != nil {
+				t.Fatalf("unshareVolumes() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(shared, tt.wantShared) {
+				t.Errorf("unshareVolumes() = %v, want %v", shared, tt.wantShared)
+			}
+
+			if !reflect.DeepEqual(hostConfig.Binds, tt.wantBinds) {
+				t.Errorf("Binds = %v, want %v", hostConfig.Binds, tt.wantBinds)
+			}
+
+			if !reflect.DeepEqual(hostConfig.Mounts, tt.wantMounts) {
+				t.Errorf("Mounts = %v, want %v", hostConfig.Mounts, tt.wantMounts)
+			}
+
+			if !reflect.DeepEqual(config.Volumes, tt.wantVolumes) {
+				t.Errorf("Volumes = %v, want %v", config.Volumes, tt.wantVolumes)
+			}
+		})
+	}
+}
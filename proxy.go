@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+)
+
+// proxy is a round-robin reverse proxy whose backends can be switched while it
+// serves, like a load balancer during a deploy
+type proxy struct {
+	mu       sync.Mutex
+	backends []string
+	next     int
+
+	listener net.Listener
+	server   *http.Server
+}
+
+// startProxy starts a proxy on a random port of the loopback interface
+func startProxy() (*proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &proxy{listener: listener}
+
+	handler := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = p.pick()
+		},
+		Transport: &http.Transport{},
+
+		// the default logs every error, which we count instead
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	p.server = &http.Server{Handler: handler}
+
+	go func() { _ = p.server.Serve(listener) }()
+
+	return p, nil
+}
+
+// addr is the address the proxy listens on
+func (p *proxy) addr() string {
+	return p.listener.Addr().String()
+}
+
+// pick returns the next backend, or an empty address if there is none
+func (p *proxy) pick() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.backends) == 0 {
+		return ""
+	}
+
+	p.next = (p.next + 1) % len(p.backends)
+
+	return p.backends[p.next]
+}
+
+// add starts sending requests to a backend
+func (p *proxy) add(backend string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.backends = append(p.backends, backend)
+}
+
+// remove stops sending new requests to a backend, the ones in flight are not
+// interrupted
+func (p *proxy) remove(backend string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var backends []string
+
+	for _, b := range p.backends {
+		if b != backend {
+			backends = append(backends, b)
+		}
+	}
+
+	p.backends = backends
+}
+
+// set replaces all backends at once
+func (p *proxy) set(backends ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.backends = backends
+}
+
+func (p *proxy) close() {
+	_ = p.server.Shutdown(context.Background())
+}
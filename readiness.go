@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// readinessInterval is how often readiness is polled
+const readinessInterval = 100 * time.Millisecond
+
+// Readiness tells how to find out that a container is ready to serve. When neither
+// Log nor HTTP are set, containers with a healthcheck are ready once healthy and any
+// other container is ready as soon as it runs.
+type Readiness struct {
+	// Log matches a line the container logs once it is ready
+	Log *regexp.Regexp
+
+	// HTTP is a port and path, such as "8080/healthz", on the container's IP address
+	// that must answer with a 2xx status
+	HTTP string
+
+	Timeout time.Duration
+}
+
+// configured tells if readiness was explicitly configured
+func (r Readiness) configured() bool {
+	return r.Log != nil || r.HTTP != ""
+}
+
+// waitReady waits for a container to become ready and returns how long after its
+// start it did so
+func waitReady(ctx context.Context, in Input, c string) (time.Duration, error) {
+	json, err := in.Docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return 0, err
+	}
+
+	started, err := time.Parse(time.RFC3339Nano, json.State.StartedAt)
+
+	if err != nil {
+		return 0, err
+	}
+
+	deadline := time.Now().Add(in.Readiness.Timeout)
+
+	for {
+		ready, err := isReady(ctx, in, json)
+
+		if err != nil {
+			return 0, err
+		}
+
+		if ready {
+			return time.Since(started), nil
+		}
+
+		if time.Now().After(deadline) {
+			return 0, fmt.Errorf("container %s was not ready after %s", json.ID[:12], in.Readiness.Timeout)
+		}
+
+		time.Sleep(readinessInterval)
+
+		if json, err = in.Docker.ContainerInspect(ctx, c); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func isReady(ctx context.Context, in Input, json types.ContainerJSON) (bool, error) {
+	if !json.State.Running {
+		return false, fmt.Errorf("container %s exited with code %d before being ready", json.ID[:12], json.State.ExitCode)
+	}
+
+	r := in.Readiness
+
+	if r.Log != nil {
+		logs, err := readLogs(ctx, in.Docker, json.ID, time.Time{})
+
+		if err != nil {
+			return false, err
+		}
+
+		if !r.Log.MatchString(logs) {
+			return false, nil
+		}
+	}
+
+	if r.HTTP != "" {
+		return probeHTTP(ctx, json, r.HTTP), nil
+	}
+
+	if !r.configured() && json.State.Health != nil {
+		return json.State.Health.Status == types.Healthy, nil
+	}
+
+	return true, nil
+}
+
+// probeHTTP sends a GET request to a port and path of the container's IP address
+func probeHTTP(ctx context.Context, json types.ContainerJSON, portPath string) bool {
+	ip := containerIP(json)
+
+	if ip == "" {
+		return false
+	}
+
+	port, path := splitPortPath(portPath)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+net.JoinHostPort(ip, port)+path, nil)
+
+	if err != nil {
+		return false
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return false
+	}
+
+	resp.Body.Close()
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// splitPortPath splits "8080/healthz" into its port and its path, which defaults
+// to the root
+func splitPortPath(portPath string) (string, string) {
+	if i := strings.Index(portPath, "/"); i >= 0 {
+		return portPath[:i], portPath[i:]
+	}
+
+	return portPath, "/"
+}
+
+// containerIP returns the first IP address the container has on any network
+func containerIP(json types.ContainerJSON) string {
+	if json.NetworkSettings == nil {
+		return ""
+	}
+
+	if json.NetworkSettings.IPAddress != "" {
+		return json.NetworkSettings.IPAddress
+	}
+
+	for _, endpoint := range json.NetworkSettings.Networks {
+		if endpoint.IPAddress != "" {
+			return endpoint.IPAddress
+		}
+	}
+
+	return ""
+}
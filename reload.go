@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/urfave/cli/v2"
+)
+
+// trafficInterval is how often traffic is sent to a container during a reload
+const trafficInterval = 50 * time.Millisecond
+
+// The outcomes of sending a reload signal
+const (
+	// ReloadReloaded means the container kept running with the same main process,
+	// and the signal had an observable effect.
+	ReloadReloaded = "Reloaded"
+
+	// ReloadRestarted means the container is running, but its main process was
+	// replaced, for instance by a restart policy.
+	ReloadRestarted = "Restarted"
+
+	// ReloadDied means the container is not running anymore.
+	ReloadDied = "Died"
+
+	// ReloadIgnored means the container kept running with the same main process,
+	// but the signal had no observable effect.
+	ReloadIgnored = "Ignored"
+)
+
+// Reload holds the options of the reload command
+type Reload struct {
+	Signal string
+
+	// Observe is how long the container is watched after the signal
+	Observe time.Duration
+
+	// Traffic is a port and path, such as "8080/", of the container's IP address
+	// that is requested continuously during the reload, if set
+	Traffic string
+
+	// Log, if set, is what the container logs once reloaded
+	Log *regexp.Regexp
+}
+
+// ReloadOutput is the outcome of reloading a single container
+type ReloadOutput struct {
+	Target  string
+	ID      string
+	ShortID string
+	Signal  string
+	Outcome string
+
+	// Ready tells if the container was ready after the reload, or why it was not;
+	// it is empty when the container is not running anymore
+	Ready string
+
+	// Requests and Failures count the traffic sent during the reload
+	Requests int
+	Failures int
+}
+
+var reloadFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "reload-signal",
+		Usage: "reload containers with this `SIGNAL`",
+		Value: "SIGHUP",
+	},
+	&cli.DurationFlag{
+		Name:  "observe",
+		Usage: "watch containers for this `DURATION` after the reload signal",
+		Value: 5 * time.Second,
+	},
+	&cli.StringFlag{
+		Name:  "traffic",
+		Usage: "continuously request this `PORT/PATH` of the container's IP address during the reload, expecting a 2xx status",
+	},
+	&cli.StringFlag{
+		Name:  "reload-log",
+		Usage: "containers reloaded once they log a line matching this `REGEX` (default: any log line, or only a change of processes with --traffic)",
+	},
+}
+
+var reloadCommand = &cli.Command{
+	Name:      "reload",
+	Usage:     "sends a reload signal to containers and verifies they reload without downtime",
+	UsageText: "grace reload [OPTIONS] [CONTAINER|IMAGE [CONTAINER|IMAGE ...]]",
+	Flags:     commandFlags(reloadFlags, runFlags, stopFlags),
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			cli.ShowCommandHelpAndExit(c, "reload", 0)
+		}
+
+		in, err := parseInput(c)
+
+		if err != nil {
+			return err
+		}
+
+		if err := checkReportHooks(in); err != nil {
+			return err
+		}
+
+		reload := Reload{
+			Signal:  normalizeSignal(c.String("reload-signal")),
+			Observe: c.Duration("observe"),
+			Traffic: c.String("traffic"),
+		}
+
+		if reload.Observe <= 0 {
+			return fmt.Errorf("observe must be positive")
+		}
+
+		if c.IsSet("reload-log") {
+			if reload.Log, err = regexp.Compile(c.String("reload-log")); err != nil {
+				return err
+			}
+		}
+
+		return runReload(in, reload, os.Stdout)
+	},
+}
+
+func runReload(in Input, reload Reload, writer io.Writer) error {
+	var data []ReloadOutput
+
+	for _, target := range in.Containers {
+		out, err := reloadOnce(context.Background(), in, reload, target)
+
+		if err != nil {
+			return err
+		}
+
+		data = append(data, out)
+	}
+
+	writeReload(writer, data)
+
+	// there is no stop result to give to the hooks
+	for _, out := range data {
+		if err := runHooks(context.Background(), in, HookEvent{Stage: HookAfterReport, ContainerID: out.ID, Signal: out.Signal}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reloadOnce sends the reload signal to the container of a target, while sending it
+// traffic if requested, and classifies how it reacted
+func reloadOnce(ctx context.Context, in Input, reload Reload, target string) (ReloadOutput, error) {
+	docker := in.Docker
+
+	c, err := provision(ctx, in, target)
+
+	if err != nil {
+		return ReloadOutput{}, err
+	}
+
+	defer cleanup(ctx, in, c)
+
+	if _, err := waitReady(ctx, in, c); err != nil {
+		return ReloadOutput{}, err
+	}
+
+	before, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return ReloadOutput{}, err
+	}
+
+	top, err := docker.ContainerTop(ctx, c, nil)
+
+	if err != nil {
+		return ReloadOutput{}, err
+	}
+
+	out := ReloadOutput{Target: target, ID: before.ID, ShortID: before.ID[:12], Signal: reload.Signal}
+
+	var wg sync.WaitGroup
+
+	done := make(chan struct{})
+
+	if reload.Traffic != "" {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(trafficInterval)
+			defer ticker.Stop()
+
+			for {
+				out.Requests++
+
+				if !probeHTTP(ctx, before, reload.Traffic) {
+					out.Failures++
+				}
+
+				select {
+				case <-ticker.C:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	signaled := time.Now()
+
+	err = docker.ContainerKill(ctx, c, reload.Signal)
+
+	if err == nil {
+		time.Sleep(reload.Observe)
+	}
+
+	close(done)
+	wg.Wait()
+
+	if err != nil {
+		return out, err
+	}
+
+	after, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return out, err
+	}
+
+	switch {
+	case !after.State.Running:
+		out.Outcome = ReloadDied
+	case after.State.Pid != before.State.Pid || after.State.StartedAt != before.State.StartedAt:
+		out.Outcome = ReloadRestarted
+	case reloaded(ctx, docker, reload, c, top, signaled):
+		out.Outcome = ReloadReloaded
+	default:
+		out.Outcome = ReloadIgnored
+	}
+
+	if after.State.Running {
+		out.Ready = "yes"
+
+		if _, err := waitReady(ctx, in, c); err != nil {
+			out.Ready = err.Error()
+		}
+	}
+
+	return out, nil
+}
+
+// reloaded tells if a container that kept its main process reloaded: it logged the
+// reload log, or else changed its processes or logged anything. Traffic makes
+// servers log every request, so logs only count when they match the reload log.
+func reloaded(ctx context.Context, docker *client.Client, reload Reload, c string, before container.ContainerTopOKBody, signaled time.Time) bool {
+	switch {
+	case reload.Log != nil:
+		logs, err := readLogs(ctx, docker, c, signaled)
+		return err == nil && reload.Log.MatchString(logs)
+	case reload.Traffic != "":
+		return processesChanged(ctx, docker, c, before)
+	default:
+		return observeEffect(ctx, docker, c, before, signaled)
+	}
+}
+
+func writeReload(writer io.Writer, data []ReloadOutput) {
+	table := newTable(writer)
+
+	var rows [][]string
+
+	for _, out := range data {
+		traffic := "-"
+
+		if out.Requests > 0 {
+			traffic = strconv.Itoa(out.Failures) + "/" + strconv.Itoa(out.Requests) + " failed"
+		}
+
+		ready := out.Ready
+
+		if ready == "" {
+			ready = "-"
+		}
+
+		rows = append(rows, []string{
+			out.Target,
+			out.ShortID,
+			out.Signal,
+			out.Outcome,
+			ready,
+			traffic,
+		})
+	}
+
+	table.SetHeader([]string{
+		"TARGET", "ID", "SIGNAL", "OUTCOME", "READY", "TRAFFIC",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+}
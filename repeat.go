@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flaky is the verdict given to targets whose runs did not all terminate the same way
+const flaky = "Flaky"
+
+// RepeatOutput aggregates the repeated runs of a single target and signal
+type RepeatOutput struct {
+	Target string
+	Signal string
+
+	Runs   int
+	Counts map[Termination]int
+
+	Min    time.Duration
+	Median time.Duration
+	P95    time.Duration
+	Max    time.Duration
+}
+
+// Verdict is the termination shared by all runs, or Flaky if they disagree
+func (r RepeatOutput) Verdict() string {
+	if len(r.Counts) != 1 {
+		return flaky
+	}
+
+	for termination := range r.Counts {
+		return termination.String()
+	}
+
+	return ""
+}
+
+// runRepeat stops fresh containers of each target repeatedly, to tell deterministic
+// failures from intermittent ones
+func runRepeat(in Input, writer io.Writer) error {
+	var data []RepeatOutput
+	var all []Output
+
+	signals := in.Signals
+
+	if len(signals) == 0 {
+		signals = []string{""}
+	}
+
+	for _, target := range in.Containers {
+		for _, signal := range signals {
+			run := in
+			run.Signal = signal
+
+			var outputs []Output
+
+			for i := 0; i < in.Repeat; i++ {
+				out, err := runOnce(context.Background(), run, target)
+
+				if err != nil {
+					return err
+				}
+
+				outputs = append(outputs, out)
+			}
+
+			data = append(data, aggregate(target, signal, outputs))
+			all = append(all, outputs...)
+		}
+	}
+
+	writeRepeat(writer, data)
+
+	return afterReport(context.Background(), in, all)
+}
+
+func aggregate(target, signal string, outputs []Output) RepeatOutput {
+	out := RepeatOutput{
+		Target: target,
+		Signal: signal,
+		Runs:   len(outputs),
+		Counts: map[Termination]int{},
+	}
+
+	var durations []time.Duration
+
+	for _, o := range outputs {
+		out.Counts[o.Termination]++
+		durations = append(durations, o.StopDuration)
+
+		// without an explicit signal, report the one that was sent by default
+		if out.Signal == "" {
+			out.Signal = o.FirstSignal
+		}
+	}
+
+	out.Min = percentile(durations, 0)
+	out.Median = percentile(durations, 50)
+	out.P95 = percentile(durations, 95)
+	out.Max = percentile(durations, 100)
+
+	return out
+}
+
+func writeRepeat(writer io.Writer, data []RepeatOutput) {
+	table := newTable(writer)
+
+	var rows [][]string
+
+	for _, out := range data {
+		rows = append(rows, []string{
+			out.Target,
+			out.Signal,
+			strconv.Itoa(out.Runs),
+			formatCounts(out.Counts),
+			out.Min.Round(time.Millisecond).String(),
+			out.Median.Round(time.Millisecond).String(),
+			out.P95.Round(time.Millisecond).String(),
+			out.Max.Round(time.Millisecond).String(),
+			out.Verdict(),
+		})
+	}
+
+	table.SetHeader([]string{
+		"TARGET", "SIGNAL", "RUNS", "TERMINATIONS", "MIN", "MEDIAN", "P95", "MAX", "VERDICT",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+}
+
+// formatCounts formats how many runs ended with each termination value, such as
+// "GracefulSuccess=4 ForceKilled=1"
+func formatCounts(counts map[Termination]int) string {
+	var terminations []Termination
+
+	for t := range counts {
+		terminations = append(terminations, t)
+	}
+
+	// sort in declaration order so that rows are stable
+	sort.Slice(terminations, func(i, j int) bool { return terminations[i] < terminations[j] })
+
+	var formatted []string
+
+	for _, t := range terminations {
+		formatted = append(formatted, fmt.Sprintf("%s=%d", t, counts[t]))
+	}
+
+	return strings.Join(formatted, " ")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The outcomes of re-sending the stop signal during the shutdown
+const (
+	// ResendGraceful means the container stayed on its graceful path and exited
+	// with a zero exit code.
+	ResendGraceful = "Graceful"
+
+	// ResendAbrupt means the container exited on its own, but with a non-zero exit
+	// code, typically because the second signal interrupted its shutdown.
+	ResendAbrupt = "Abrupt"
+
+	// ResendHang means the container did not exit on its own and had to be killed.
+	ResendHang = "Hang"
+
+	// ResendNotReached means the container exited before the signal could be sent
+	// again, so the scenario was not exercised.
+	ResendNotReached = "NotReached"
+)
+
+// getResendOutcome classifies how a container reacted to its stop signal being sent
+// more than once
+func getResendOutcome(termination Termination, stop Stop) string {
+	switch {
+	case stop.Signal == killSignal:
+		return ResendHang
+	case stop.Resent == 0:
+		return ResendNotReached
+	case termination == GracefulSuccess:
+		return ResendGraceful
+	}
+
+	return ResendAbrupt
+}
+
+func writeResend(writer io.Writer, data []Output) {
+	var rows [][]string
+
+	for _, out := range data {
+		if out.Resend == "" {
+			continue
+		}
+
+		rows = append(rows, []string{
+			out.ShortID,
+			out.FirstSignal,
+			strconv.Itoa(out.Resent),
+			out.Resend,
+		})
+	}
+
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintln(writer)
+
+	table := newTable(writer)
+
+	table.SetHeader([]string{
+		"ID", "SIGNAL", "RESENT", "RESULT",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+}
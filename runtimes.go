@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// The runtimes and servers grace recognizes, besides the known servers
+const (
+	RuntimeJVM      = "jvm"
+	RuntimeNode     = "node"
+	RuntimeNPM      = "npm"
+	RuntimePython   = "python"
+	RuntimeGunicorn = "gunicorn"
+	RuntimeUvicorn  = "uvicorn"
+)
+
+const (
+	// gunicornGracefulTimeout is how long gunicorn gives its workers to finish by
+	// default
+	gunicornGracefulTimeout = 30 * time.Second
+
+	// springShutdownPhase is the setting bounding how long each phase of a Spring
+	// Boot graceful shutdown takes
+	springShutdownPhase = "spring.lifecycle.timeout-per-shutdown-phase"
+)
+
+// runtimeDetector recognizes a runtime from the programs of the processes of a
+// container, or else from the environment variables its official image sets
+type runtimeDetector struct {
+	Name     string
+	Programs []string
+	Env      []string
+}
+
+// runtimeDetectors are tried in order, the more specific runtimes first
+var runtimeDetectors = []runtimeDetector{
+	{RuntimeGunicorn, []string{"gunicorn"}, nil},
+	{RuntimeUvicorn, []string{"uvicorn"}, nil},
+	{RuntimeNPM, []string{"npm", "yarn", "pnpm"}, nil},
+	{RuntimeJVM, []string{"java"}, []string{"JAVA_HOME", "JAVA_VERSION"}},
+	{RuntimeNode, []string{"node", "nodejs"}, []string{"NODE_VERSION"}},
+	{RuntimePython, []string{"python"}, []string{"PYTHON_VERSION"}},
+	{"nginx", []string{"nginx"}, []string{"NGINX_VERSION"}},
+	{"httpd", []string{"httpd"}, []string{"HTTPD_VERSION"}},
+	{"apache2", []string{"apache2"}, nil},
+	{"php-fpm", []string{"php-fpm"}, nil},
+	{"haproxy", []string{"haproxy"}, []string{"HAPROXY_VERSION"}},
+	{"postgres", []string{"postgres"}, []string{"PG_VERSION"}},
+}
+
+// Runtime is a runtime or server detected in a container
+type Runtime struct {
+	Name string
+
+	// Program is the name of the program it was detected from, and Args the
+	// command line of its process. Both are empty if it was detected from the image
+	// only.
+	Program string
+	Args    []string
+}
+
+// Recommendation is an advice specific to a runtime detected in a container
+type Recommendation struct {
+	Runtime  string
+	Severity string
+	Message  string
+}
+
+// detectRuntimes recognizes the runtimes of a running container from its process
+// list and its configuration. It never fails: what cannot be read is ignored.
+func detectRuntimes(ctx context.Context, docker *client.Client, json types.ContainerJSON) []Runtime {
+	var commands [][]string
+
+	if top, err := docker.ContainerTop(ctx, json.ID, nil); err == nil {
+		for _, row := range top.Processes {
+			for i, title := range top.Titles {
+				if (title == "CMD" || title == "COMMAND") && i < len(row) {
+					commands = append(commands, strings.Fields(row[i]))
+				}
+			}
+		}
+	}
+
+	var runtimes []Runtime
+
+	for _, d := range runtimeDetectors {
+		if r, ok := d.detect(commands, json.Config.Env); ok {
+			runtimes = append(runtimes, r)
+		}
+	}
+
+	return runtimes
+}
+
+// detect looks for the runtime among the first words of each command, since
+// interpreters run scripts such as gunicorn, then among the environment variables
+func (d runtimeDetector) detect(commands [][]string, env []string) (Runtime, bool) {
+	for _, args := range commands {
+		for i := 0; i < len(args) && i < 3; i++ {
+			if contains(d.Programs, programName(args[i])) {
+				return Runtime{Name: d.Name, Program: programName(args[i]), Args: args}, true
+			}
+		}
+	}
+
+	for _, v := range env {
+		if contains(d.Env, strings.SplitN(v, "=", 2)[0]) {
+			return Runtime{Name: d.Name}, true
+		}
+	}
+
+	return Runtime{}, false
+}
+
+// programName is the name of a program without its directory and version, as in
+// python3.11 or php-fpm8.2, nor the colon of process titles such as "nginx: master"
+func programName(word string) string {
+	return strings.TrimRight(path.Base(word), "0123456789.:")
+}
+
+// recommend checks the settings of the detected runtimes against how the container
+// was stopped, and how it terminated
+func recommend(runtimes []Runtime, config *container.Config, out Output) []Recommendation {
+	var recommendations []Recommendation
+
+	names := map[string]bool{}
+
+	for _, r := range runtimes {
+		names[r.Name] = true
+	}
+
+	signal := out.FirstSignal
+	timeout := out.Timeout
+	failed := out.Termination == ForceKilled || out.Termination == SignalIgnored
+
+	for _, r := range runtimes {
+		add := func(severity, format string, args ...interface{}) {
+			recommendations = append(recommendations, Recommendation{
+				Runtime:  r.Name,
+				Severity: severity,
+				Message:  fmt.Sprintf(format, args...),
+			})
+		}
+
+		settings := append(append([]string{}, r.Args...), envArgs(config.Env)...)
+
+		switch r.Name {
+		case RuntimeJVM:
+			if signal != "SIGTERM" && signal != "SIGINT" && signal != "SIGHUP" {
+				add(SeverityWarning, "the JVM only runs its shutdown hooks on SIGTERM, SIGINT or SIGHUP, but was sent %s; set STOPSIGNAL SIGTERM", signal)
+			}
+
+			if phase, ok := flagDuration(settings, springShutdownPhase); ok && phase >= timeout {
+				add(SeverityWarning, "Spring Boot waits up to %s per shutdown phase, but the container is killed after %s; lower %s", phase, timeout, springShutdownPhase)
+			} else if failed {
+				add(SeverityWarning, "shutdown hooks did not finish within %s; keep Runtime.addShutdownHook handlers short, and with Spring Boot set server.shutdown=graceful and %s below it", timeout, springShutdownPhase)
+			}
+
+			if out.ExitCode == 143 {
+				add(SeverityInfo, "exit code 143 is how the JVM reports a SIGTERM after running its shutdown hooks; call System.exit(0) once shut down, or treat 143 as a success")
+			}
+
+		case RuntimeNode:
+			if failed {
+				add(SeverityError, "Node has no default handler for %s and ignores it as PID 1; add process.on('%s', ...) closing the server, or run with --init", signal, signal)
+			} else if out.ExitCode == 130 || out.ExitCode == 143 {
+				add(SeverityWarning, "Node exited on its default %s handler, without closing its connections; add process.on('%s', ...) closing the server", signal, signal)
+			}
+
+		case RuntimeNPM:
+			add(SeverityWarning, "%s runs the application as a child process and may not forward %s to it; run node directly in the command", r.Program, signal)
+
+		case RuntimeGunicorn:
+			graceful := gunicornGracefulTimeout
+
+			if seconds, ok := flagValue(settings, "graceful-timeout"); ok {
+				if n, err := strconv.Atoi(seconds); err == nil {
+					graceful = time.Duration(n) * time.Second
+				}
+			}
+
+			if graceful >= timeout {
+				add(SeverityWarning, "gunicorn gives its workers %s to finish their requests (--graceful-timeout), but the container is killed after %s; lower --graceful-timeout or raise the stop timeout", graceful, timeout)
+			}
+
+			if signal == "SIGINT" || signal == "SIGQUIT" {
+				add(SeverityWarning, "gunicorn shuts down immediately on %s, dropping requests in flight; set STOPSIGNAL SIGTERM", signal)
+			}
+
+		case RuntimeUvicorn:
+			if graceful, ok := flagValue(settings, "timeout-graceful-shutdown"); !ok {
+				add(SeverityWarning, "uvicorn waits for open connections without limit; set --timeout-graceful-shutdown below the %s stop timeout", timeout)
+			} else if n, err := strconv.Atoi(graceful); err == nil && time.Duration(n)*time.Second >= timeout {
+				add(SeverityWarning, "uvicorn waits %ss for open connections (--timeout-graceful-shutdown), but the container is killed after %s; lower it", graceful, timeout)
+			}
+
+		case RuntimePython:
+			// the servers handle signals themselves
+			if failed && signal == "SIGTERM" && !names[RuntimeGunicorn] && !names[RuntimeUvicorn] {
+				add(SeverityError, "Python has no handler for SIGTERM and ignores it as PID 1; install one with signal.signal raising SystemExit, or set STOPSIGNAL SIGINT to get a KeyboardInterrupt")
+			}
+
+		default:
+			if server, ok := knownServers[r.Name]; ok && signal != server.Signal {
+				add(SeverityWarning, "%s shuts down gracefully on %s, but was sent %s; set STOPSIGNAL %s", r.Name, server.Signal, signal, server.Signal)
+			}
+		}
+	}
+
+	return recommendations
+}
+
+// envArgs are the command line arguments given through the environment, which
+// gunicorn and the JVM read as well as their own
+func envArgs(env []string) []string {
+	var args []string
+
+	for _, v := range env {
+		kv := strings.SplitN(v, "=", 2)
+
+		if len(kv) < 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "GUNICORN_CMD_ARGS", "JAVA_TOOL_OPTIONS", "JDK_JAVA_OPTIONS", "JAVA_OPTS":
+			args = append(args, strings.Fields(kv[1])...)
+		case "SPRING_LIFECYCLE_TIMEOUTPERSHUTDOWNPHASE", "SPRING_LIFECYCLE_TIMEOUT_PER_SHUTDOWN_PHASE":
+			args = append(args, "--"+springShutdownPhase+"="+kv[1])
+		}
+	}
+
+	return args
+}
+
+// flagValue finds the value of a command line option, given as --name=value,
+// --name value or as a Java system property -Dname=value
+func flagValue(args []string, name string) (string, bool) {
+	for i, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--"+name+"="):
+			return strings.TrimPrefix(arg, "--"+name+"="), true
+		case strings.HasPrefix(arg, "-D"+name+"="):
+			return strings.TrimPrefix(arg, "-D"+name+"="), true
+		case arg == "--"+name && i+1 < len(args):
+			return args[i+1], true
+		}
+	}
+
+	return "", false
+}
+
+// flagDuration reads an option given as a duration, such as 30s, or as seconds
+func flagDuration(args []string, name string) (time.Duration, bool) {
+	value, ok := flagValue(args, name)
+
+	if !ok {
+		return 0, false
+	}
+
+	if n, err := strconv.Atoi(value); err == nil {
+		return time.Duration(n) * time.Second, true
+	}
+
+	d, err := time.ParseDuration(value)
+
+	return d, err == nil
+}
+
+func writeRecommendations(writer io.Writer, data []Output) {
+	for _, out := range data {
+		if len(out.Recommendations) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nRecommendations for %s, given its runtimes:\n\n", out.ShortID)
+
+		table := newTable(writer)
+		table.SetHeader([]string{"RUNTIME", "SEVERITY", "RECOMMENDATION"})
+
+		for _, r := range out.Recommendations {
+			table.Append([]string{r.Runtime, r.Severity, r.Message})
+		}
+
+		table.Render()
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// The lint rules of entrypoint scripts
+const (
+	RuleMissingExec = "missing-exec"
+	RuleBackground  = "background"
+	RuleTrap        = "trap"
+)
+
+// defaultPath is where programs are looked up when the image doesn't set PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// maxScriptSize is how much of an entrypoint script is read
+const maxScriptSize = 1 << 20
+
+var (
+	shebangShell = regexp.MustCompile(`^#!\s*(\S*/)?(env\s+)?(sh|bash|ash|dash|ksh|zsh)\b`)
+	functionDef  = regexp.MustCompile(`^(function\s+)?[A-Za-z_][A-Za-z0-9_-]*\s*\(\)\s*\{?\s*$|^function\s+[A-Za-z_][A-Za-z0-9_-]*\s*\{?\s*$`)
+	trapCommand  = regexp.MustCompile(`^trap\s+('[^']*'|"[^"]*"|\S+)\s+(.+)$`)
+	background   = regexp.MustCompile(`[^&]&\s*(#.*)?$`)
+)
+
+// trapConditions are what trap takes besides signals: shell events such as the
+// exit of the script, which are not signals
+var trapConditions = []string{"EXIT", "0", "ERR", "DEBUG", "RETURN"}
+
+// scriptPath finds the shell script a command starts, if any: either the program
+// itself, or the script given to a shell or an init process
+func scriptPath(command []string) string {
+	words := command
+
+	// a shell form command is only a script if it starts one
+	if len(words) == 3 && words[1] == "-c" {
+		var ok bool
+
+		if words, ok = shellWords(words[2]); !ok {
+			return ""
+		}
+	}
+
+	for len(words) > 0 {
+		program := path.Base(words[0])
+
+		switch {
+		case words[0] == "exec" || isInit(program) || program == "sh" || program == "bash" || program == "ash" || program == "dash":
+			words = words[1:]
+
+			// skip the options of the init process or the shell
+			for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+				if words[0] == "--" {
+					words = words[1:]
+					break
+				}
+
+				if words[0] == "-c" {
+					return ""
+				}
+
+				words = words[1:]
+			}
+
+		default:
+			return words[0]
+		}
+	}
+
+	return ""
+}
+
+// resolveScript returns the paths where the program of a container configuration
+// may be found, in order
+func resolveScript(config *container.Config, program string) []string {
+	if strings.Contains(program, "/") {
+		if path.IsAbs(program) {
+			return []string{program}
+		}
+
+		dir := config.WorkingDir
+
+		if dir == "" {
+			dir = "/"
+		}
+
+		return []string{path.Join(dir, program)}
+	}
+
+	search := defaultPath
+
+	for _, env := range config.Env {
+		if strings.HasPrefix(env, "PATH=") {
+			search = strings.TrimPrefix(env, "PATH=")
+		}
+	}
+
+	var paths []string
+
+	for _, dir := range strings.Split(search, ":") {
+		if dir != "" {
+			paths = append(paths, path.Join(dir, program))
+		}
+	}
+
+	return paths
+}
+
+// fileReader reads a regular file of an image, following symbolic links, and
+// returns nil if there is no such file
+type fileReader func(file string) ([]byte, error)
+
+// findScript reads the program started by a container configuration, if it may be
+// a script
+func findScript(config *container.Config, read fileReader) (string, []byte, error) {
+	program := scriptPath(append(append([]string{}, config.Entrypoint...), config.Cmd...))
+
+	if program == "" {
+		return "", nil, nil
+	}
+
+	for _, candidate := range resolveScript(config, program) {
+		content, err := read(candidate)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		if content != nil {
+			return candidate, content, nil
+		}
+	}
+
+	return "", nil, nil
+}
+
+// containerFiles reads the files of an image out of a container created from it,
+// which is never started, and returns a function that removes the container
+func containerFiles(ctx context.Context, docker *client.Client, image string) (fileReader, func()) {
+	var c string
+
+	read := func(file string) ([]byte, error) {
+		// the container is only created if there is a file to read
+		if c == "" {
+			created, err := docker.ContainerCreate(ctx, &container.Config{Image: image}, nil, nil, nil, "")
+
+			if err != nil {
+				return nil, err
+			}
+
+			c = created.ID
+		}
+
+		return copyFile(ctx, docker, c, file)
+	}
+
+	remove := func() {
+		if c != "" {
+			_ = docker.ContainerRemove(ctx, c, types.ContainerRemoveOptions{Force: true})
+		}
+	}
+
+	return read, remove
+}
+
+// copyFile reads a regular file of a container, following symbolic links, and
+// returns nil if there is no such file
+func copyFile(ctx context.Context, docker *client.Client, c, file string) ([]byte, error) {
+	for links := 0; links < 10; links++ {
+		content, _, err := docker.CopyFromContainer(ctx, c, file)
+
+		if errdefs.IsNotFound(err) {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		archive := tar.NewReader(content)
+		header, err := archive.Next()
+
+		if err != nil {
+			content.Close()
+			return nil, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			data, err := io.ReadAll(io.LimitReader(archive, maxScriptSize))
+			content.Close()
+
+			return data, err
+
+		case tar.TypeSymlink:
+			content.Close()
+
+			if path.IsAbs(header.Linkname) {
+				file = header.Linkname
+			} else {
+				file = path.Join(path.Dir(file), header.Linkname)
+			}
+
+		default:
+			content.Close()
+			return nil, nil
+		}
+	}
+
+	return nil, fmt.Errorf("too many symbolic links for %s", file)
+}
+
+// isShellScript tells if a file is a shell script, from its shebang
+func isShellScript(content []byte) bool {
+	line, _, _ := bufio.NewReader(bytes.NewReader(content)).ReadLine()
+
+	return shebangShell.Match(line)
+}
+
+// analyzeScript looks for the usual reasons an entrypoint script doesn't pass the
+// stop signal on: a final command run without exec, background processes that are
+// never waited for, and traps, which are reported so they can be reviewed
+func analyzeScript(target string, content []byte, stopSignal string) []Finding {
+	var findings []Finding
+
+	add := func(line int, severity, rule, format string, args ...interface{}) {
+		findings = append(findings, Finding{
+			Target:   target + ":" + strconv.Itoa(line),
+			Severity: severity,
+			Rule:     rule,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if stopSignal == "" {
+		stopSignal = defaultStopSignal
+	}
+
+	stopSignal = normalizeSignal(stopSignal)
+
+	var trapped []string
+	var backgrounds []int
+	var waits bool
+
+	// the last command run by the script itself, outside of any function
+	lastLine, last := 0, ""
+	depth := 0
+
+	// opening tells that a function was defined with its body on the next line
+	opening := false
+
+	// continued holds the lines ending in a backslash, which go on with the next
+	// one, and n is the first of them
+	var continued string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	n, physical := 0, 0
+
+	for scanner.Scan() {
+		physical++
+		line := strings.TrimSpace(scanner.Text())
+
+		if continued == "" {
+			n = physical
+
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+		}
+
+		if strings.HasSuffix(line, `\`) {
+			continued += strings.TrimSpace(strings.TrimSuffix(line, `\`)) + " "
+			continue
+		}
+
+		line, continued = strings.TrimSpace(continued+line), ""
+
+		// function bodies only run when called, so they are not the last command
+		if functionDef.MatchString(line) {
+			if strings.HasSuffix(line, "{") {
+				depth++
+			} else {
+				opening = true
+			}
+
+			continue
+		}
+
+		// the body of a function may open on the line after its name
+		if opening && strings.HasPrefix(line, "{") {
+			depth++
+			line = line[1:]
+		}
+
+		opening = false
+
+		if depth > 0 {
+			depth += strings.Count(line, "{") - strings.Count(line, "}")
+			continue
+		}
+
+		if m := trapCommand.FindStringSubmatch(line); m != nil {
+			var signals []string
+
+			for _, s := range strings.Fields(strings.SplitN(m[2], "#", 2)[0]) {
+				if contains(trapConditions, strings.ToUpper(s)) {
+					continue
+				}
+
+				signal := normalizeSignal(s)
+
+				signals = append(signals, signal)
+
+				if signal == killSignal || signal == "SIGSTOP" {
+					add(n, SeverityWarning, RuleTrap, "%s cannot be trapped, this trap never runs", signal)
+				}
+			}
+
+			switch {
+			case len(signals) == 0:
+				// only shell events are trapped
+			case m[1] == "''" || m[1] == `""`:
+				// ignored signals stay ignored in the programs the script runs
+				for _, signal := range signals {
+					if signal == stopSignal {
+						add(n, SeverityError, RuleTrap, "ignores the stop signal %s, and so do the programs the script runs unless they handle it", signal)
+					} else {
+						add(n, SeverityWarning, RuleTrap, "ignores %s, and so do the programs the script runs unless they handle it", signal)
+					}
+				}
+			case m[1] == "-":
+				// resets the signals to their default action
+			default:
+				trapped = append(trapped, signals...)
+				add(n, SeverityInfo, RuleTrap, "traps %s with %s", strings.Join(signals, ", "), m[1])
+			}
+
+			continue
+		}
+
+		if isWait(line) {
+			waits = true
+		}
+
+		if background.MatchString(line) {
+			backgrounds = append(backgrounds, n)
+		}
+
+		switch strings.Fields(line)[0] {
+		case "fi", "done", "esac", "else", "then", "do", "}", ";;", "exit":
+			continue
+		}
+
+		lastLine, last = n, line
+	}
+
+	if !waits {
+		for _, line := range backgrounds {
+			add(line, SeverityWarning, RuleBackground, "runs a process in the background without waiting for it, so it is killed without being signaled when the script exits")
+		}
+	}
+
+	if len(trapped) > 0 && !contains(trapped, stopSignal) {
+		add(1, SeverityWarning, RuleTrap, "traps %s but not the stop signal %s", strings.Join(trapped, ", "), stopSignal)
+	}
+
+	// a script that handles the stop signal itself doesn't need to pass it on
+	handles := contains(trapped, stopSignal) && waits
+
+	if last != "" && !handles && !strings.HasPrefix(last, "exec ") && !isWait(last) && !background.MatchString(last) {
+		add(lastLine, SeverityError, RuleMissingExec, "`%s` runs as a child of the shell, which does not forward the stop signal to it; use exec", last)
+	}
+
+	return findings
+}
+
+// isWait tells if a line of a script waits for background processes, as in "wait",
+// "wait $!" or "wait -n"
+func isWait(line string) bool {
+	return line == "wait" || strings.HasPrefix(line, "wait ")
+}
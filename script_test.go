@@ -0,0 +1,232 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeScript(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		stopSignal string
+		want       []Finding
+	}{
+		{
+			name:   "exec",
+			script: "#!/bin/sh\nset -e\nexec ./app \"$@\"\n",
+		},
+		{
+			name:   "missing exec",
+			script: "#!/bin/sh\nset -e\n./migrate\n./app \"$@\"\n",
+			want: []Finding{
+				{Target: "run.sh:4", Severity: SeverityError, Rule: RuleMissingExec, Message: "`./app \"$@\"` runs as a child of the shell, which does not forward the stop signal to it; use exec"},
+			},
+		},
+		{
+			name: "functions",
+			script: `#!/bin/sh
+setup() {
+  ./migrate
+}
+function cleanup {
+  rm -f /tmp/app.pid
+}
+setup
+./app
+`,
+			want: []Finding{
+				{Target: "run.sh:9", Severity: SeverityError, Rule: RuleMissingExec, Message: "`./app` runs as a child of the shell, which does not forward the stop signal to it; use exec"},
+			},
+		},
+		{
+			name: "allman style functions",
+			script: `#!/bin/bash
+setup()
+{
+  if [ -n "$DEBUG" ]; then
+    set -x
+  fi
+}
+
+function cleanup
+{ rm -f /tmp/app.pid; }
+
+setup
+./app
+`,
+			want: []Finding{
+				{Target: "run.sh:13", Severity: SeverityError, Rule: RuleMissingExec, Message: "`./app` runs as a child of the shell, which does not forward the stop signal to it; use exec"},
+			},
+		},
+		{
+			name: "background without wait",
+			script: `#!/bin/sh
+./sidecar &
+exec ./app
+`,
+			want: []Finding{
+				{Target: "run.sh:2", Severity: SeverityWarning, Rule: RuleBackground, Message: "runs a process in the background without waiting for it, so it is killed without being signaled when the script exits"},
+			},
+		},
+		{
+			name: "trap and wait",
+			script: `#!/bin/sh
+trap 'kill -TERM $child' TERM INT
+./app &
+child=$!
+wait
+`,
+			want: []Finding{
+				{Target: "run.sh:2", Severity: SeverityInfo, Rule: RuleTrap, Message: "traps SIGTERM, SIGINT with 'kill -TERM $child'"},
+			},
+		},
+		{
+			name: "trap of another signal",
+			script: `#!/bin/sh
+trap 'kill $child' 2
+./app &
+child=$!
+wait
+`,
+			stopSignal: "15",
+			want: []Finding{
+				{Target: "run.sh:2", Severity: SeverityInfo, Rule: RuleTrap, Message: "traps SIGINT with 'kill $child'"},
+				{Target: "run.sh:1", Severity: SeverityWarning, Rule: RuleTrap, Message: "traps SIGINT but not the stop signal SIGTERM"},
+			},
+		},
+		{
+			name: "ignored signals",
+			script: `#!/bin/sh
+trap '' TERM
+trap "" HUP
+./app &
+wait
+`,
+			want: []Finding{
+				{Target: "run.sh:2", Severity: SeverityError, Rule: RuleTrap, Message: "ignores the stop signal SIGTERM, and so do the programs the script runs unless they handle it"},
+				{Target: "run.sh:3", Severity: SeverityWarning, Rule: RuleTrap, Message: "ignores SIGHUP, and so do the programs the script runs unless they handle it"},
+			},
+		},
+		{
+			name: "reset signal",
+			script: `#!/bin/sh
+trap - TERM
+./app
+`,
+			want: []Finding{
+				{Target: "run.sh:3", Severity: SeverityError, Rule: RuleMissingExec, Message: "`./app` runs as a child of the shell, which does not forward the stop signal to it; use exec"},
+			},
+		},
+		{
+			name: "untrappable signal",
+			script: `#!/bin/sh
+trap 'echo bye' KILL
+exec ./app
+`,
+			want: []Finding{
+				{Target: "run.sh:2", Severity: SeverityWarning, Rule: RuleTrap, Message: "SIGKILL cannot be trapped, this trap never runs"},
+				{Target: "run.sh:2", Severity: SeverityInfo, Rule: RuleTrap, Message: "traps SIGKILL with 'echo bye'"},
+				{Target: "run.sh:1", Severity: SeverityWarning, Rule: RuleTrap, Message: "traps SIGKILL but not the stop signal SIGTERM"},
+			},
+		},
+		{
+			name: "control structures",
+			script: `#!/bin/sh
+if [ "$1" = "migrate" ]; then
+  exec ./migrate
+else
+  exec ./app
+fi
+`,
+		},
+		{
+			name: "continued lines",
+			script: `#!/bin/sh
+exec ./app \
+  --port 80 \
+  --debug
+`,
+		},
+		{
+			name: "continued lines missing exec",
+			script: `#!/bin/sh
+# a comment \
+./app \
+  --port 80
+`,
+			want: []Finding{
+				{Target: "run.sh:3", Severity: SeverityError, Rule: RuleMissingExec, Message: "`./app --port 80` runs as a child of the shell, which does not forward the stop signal to it; use exec"},
+			},
+		},
+		{
+			name: "wait for a process",
+			script: `#!/bin/sh
+trap 'kill $pid' TERM
+./app &
+pid=$!
+wait "$pid"
+`,
+			want: []Finding{
+				{Target: "run.sh:2", Severity: SeverityInfo, Rule: RuleTrap, Message: "traps SIGTERM with 'kill $pid'"},
+			},
+		},
+		{
+			name:   "wait for the last process",
+			script: "#!/bin/sh\n./app &\nwait $!\n",
+		},
+		{
+			name:   "wait for any process",
+			script: "#!/bin/bash\n./app &\n./sidecar &\nwait -n\n",
+		},
+		{
+			name: "trapped shell events",
+			script: `#!/bin/bash
+trap cleanup EXIT
+trap 'echo failed' ERR
+trap '' 0
+trap 'kill $pid' TERM exit
+./app &
+pid=$!
+wait
+`,
+			want: []Finding{
+				{Target: "run.sh:5", Severity: SeverityInfo, Rule: RuleTrap, Message: "traps SIGTERM with 'kill $pid'"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := analyzeScript("run.sh", []byte(tt.script), tt.stopSignal)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("analyzeScript() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		command []string
+		want    string
+	}{
+		{name: "program", command: []string{"/docker-entrypoint.sh", "nginx"}, want: "/docker-entrypoint.sh"},
+		{name: "shell", command: []string{"sh", "-e", "./run.sh"}, want: "./run.sh"},
+		{name: "init", command: []string{"tini", "--", "./run.sh"}, want: "./run.sh"},
+		{name: "shell form", command: []string{"/bin/sh", "-c", "exec ./run.sh --port 80"}, want: "./run.sh"},
+		{name: "shell form needing a shell", command: []string{"/bin/sh", "-c", "./run.sh | tee log"}},
+		{name: "shell form program", command: []string{"bash", "-c", "echo"}, want: "echo"},
+		{name: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scriptPath(tt.command); got != tt.want {
+				t.Errorf("scriptPath(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
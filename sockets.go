@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// socketInterval is how often the sockets of a container are sampled while it stops
+const socketInterval = 20 * time.Millisecond
+
+// SocketLifecycle tells when a container closed the sockets it had open when it was
+// sent the first signal
+type SocketLifecycle struct {
+	Listeners   []TrackedSocket
+	Connections []TrackedSocket
+}
+
+// TrackedSocket is a listening socket or an established connection, followed
+// during the shutdown
+type TrackedSocket struct {
+	Local  string
+	Remote string
+
+	// Closed is when the application closed the socket, relative to the first
+	// signal. It is only set if the socket was closed before the container exited,
+	// otherwise the kernel tore it down on exit.
+	Closed *time.Duration
+}
+
+// socketTracker samples the TCP sockets of a container through the host /proc
+type socketTracker struct {
+	pid int
+
+	mu      sync.Mutex
+	initial []Socket
+	samples []socketSample
+}
+
+type socketSample struct {
+	at      time.Time
+	sockets []Socket
+}
+
+// newSocketTracker takes the first sample of the sockets of a running container,
+// which grace must be able to see from the host
+func newSocketTracker(ctx context.Context, docker *client.Client, c string) (*socketTracker, error) {
+	json, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasHostProc(json.State.Pid) {
+		return nil, fmt.Errorf("tracking sockets requires grace to run on the host of container %s", json.ID[:12])
+	}
+
+	initial, err := readSockets(json.State.Pid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &socketTracker{pid: json.State.Pid, initial: initial}, nil
+}
+
+// run samples the sockets until done is closed or the container's main process is
+// gone, so the last sample is the state right before the exit
+func (t *socketTracker) run(done <-chan struct{}) {
+	ticker := time.NewTicker(socketInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		sockets, err := readSockets(t.pid)
+
+		// while the process exits, the kernel closes its sockets on its behalf, so
+		// only samples of a live process count
+		if err != nil || !t.alive() {
+			return
+		}
+
+		t.mu.Lock()
+		t.samples = append(t.samples, socketSample{at: time.Now(), sockets: sockets})
+		t.mu.Unlock()
+	}
+}
+
+func (t *socketTracker) alive() bool {
+	p, err := readProcess(t.pid)
+
+	return err == nil && p.State != "Z" && p.State != "X"
+}
+
+// lifecycle follows each socket open at the first sample through the next ones, and
+// tells when it was closed relative to the given signal time
+func (t *socketTracker) lifecycle(signaled time.Time) *SocketLifecycle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lc := &SocketLifecycle{}
+
+	for _, s := range t.initial {
+		var open func(Socket) bool
+
+		switch s.State {
+		case "LISTEN":
+			open = func(o Socket) bool { return o.State == "LISTEN" && o.Local == s.Local }
+		case "ESTABLISHED":
+			// a connection the peer closed is still open until the application
+			// closes it too
+			open = func(o Socket) bool {
+				return (o.State == "ESTABLISHED" || o.State == "CLOSE_WAIT") && o.Local == s.Local && o.Remote == s.Remote
+			}
+		default:
+			continue
+		}
+
+		tracked := TrackedSocket{Local: s.Local, Remote: s.Remote}
+
+		for _, sample := range t.samples {
+			if !sample.at.After(signaled) || anySocket(sample.sockets, open) {
+				continue
+			}
+
+			closed := sample.at.Sub(signaled)
+			tracked.Closed = &closed
+
+			break
+		}
+
+		if s.State == "LISTEN" {
+			tracked.Remote = ""
+			lc.Listeners = append(lc.Listeners, tracked)
+		} else {
+			lc.Connections = append(lc.Connections, tracked)
+		}
+	}
+
+	return lc
+}
+
+func anySocket(sockets []Socket, match func(Socket) bool) bool {
+	for _, s := range sockets {
+		if match(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeSockets(writer io.Writer, data []Output) {
+	for _, out := range data {
+		lc := out.Sockets
+
+		if lc == nil {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nSockets of %s after the %s signal:\n\n", out.ShortID, out.FirstSignal)
+
+		table := newTable(writer)
+		table.SetHeader([]string{"KIND", "LOCAL", "REMOTE", "CLOSED"})
+
+		row := func(kind string, s TrackedSocket) []string {
+			closed := "by the kernel on exit"
+
+			if s.Closed != nil {
+				closed = "after " + s.Closed.Round(time.Millisecond).String()
+			}
+
+			remote := s.Remote
+
+			if remote == "" {
+				remote = "-"
+			}
+
+			return []string{kind, s.Local, remote, closed}
+		}
+
+		for _, s := range lc.Listeners {
+			table.Append(row("listener", s))
+		}
+
+		for _, s := range lc.Connections {
+			table.Append(row("connection", s))
+		}
+
+		table.Render()
+	}
+}
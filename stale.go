@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	// changeAdded is the kind of the files added to a container, see ContainerDiff
+	changeAdded = 1
+
+	// staleImage lists the files of the volumes, it only needs find and stat
+	staleImage = "busybox"
+
+	// staleTimeout is how long listing the files of the volumes may take
+	staleTimeout = time.Minute
+)
+
+// stalePatterns match the names of the files that commonly prevent an application
+// from starting again when they are left behind
+var stalePatterns = []string{
+	"*.pid", "*.lock", "*.lck", "LOCK", "lock", "*.sock", "*.socket",
+}
+
+// StaleFile is a file created during the life of a container which is still present
+// after it exited, and may prevent it from starting again
+type StaleFile struct {
+	Path string
+
+	// Source is where the file was found: the container's filesystem or a volume
+	Source string
+}
+
+// findStaleFiles lists the files matching the stale patterns that were created by
+// an exited container, in its own filesystem or in its volumes
+func findStaleFiles(ctx context.Context, in Input, json types.ContainerJSON) ([]StaleFile, error) {
+	var stale []StaleFile
+
+	changes, err := in.Docker.ContainerDiff(ctx, json.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, change := range changes {
+		if change.Kind == changeAdded && matchesStale(in, change.Path) {
+			stale = append(stale, StaleFile{Path: change.Path, Source: "container"})
+		}
+	}
+
+	started, err := time.Parse(time.RFC3339Nano, json.State.StartedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// volumes are not part of the diff, so we look for files modified since the
+	// container started instead
+	var mounts []types.MountPoint
+
+	for _, m := range json.Mounts {
+		if m.Type == mount.TypeVolume || m.Type == mount.TypeBind {
+			mounts = append(mounts, m)
+		}
+	}
+
+	if len(mounts) == 0 {
+		return stale, nil
+	}
+
+	listing, err := listMounts(ctx, in, json.ID, mounts)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the volumes: %w", err)
+	}
+
+	for _, f := range parseListing(in, listing, started) {
+		stale = append(stale, StaleFile{Path: f, Source: mountSource(mounts, f)})
+	}
+
+	return stale, nil
+}
+
+// listMounts lists the names and modification times of the files matching the stale
+// patterns in the mounts of a container, from a helper container sharing its
+// volumes. Unlike copying them from the container, the content of the files is never
+// transferred.
+func listMounts(ctx context.Context, in Input, c string, mounts []types.MountPoint) (string, error) {
+	if err := pullImage(ctx, in.Docker, staleImage); err != nil {
+		return "", err
+	}
+
+	cmd := []string{"find"}
+
+	for _, m := range mounts {
+		cmd = append(cmd, m.Destination)
+	}
+
+	cmd = append(cmd, "!", "-type", "d", "(")
+
+	for i, pattern := range append(stalePatterns, in.StalePatterns...) {
+		if i > 0 {
+			cmd = append(cmd, "-o")
+		}
+
+		cmd = append(cmd, "-name", pattern)
+	}
+
+	cmd = append(cmd, ")", "-exec", "stat", "-c", "%Y %n", "{}", "+")
+
+	config := &container.Config{Image: staleImage, Entrypoint: cmd}
+	hostConfig := &container.HostConfig{VolumesFrom: []string{c}}
+
+	// find fails on the files it cannot read, but still lists the others
+	logs, _, err := runToCompletion(ctx, in.Docker, config, hostConfig, staleTimeout)
+
+	return logs, err
+}
+
+// parseListing returns the files of a listing of modification times in seconds
+// followed by names, as written by stat -c "%Y %n", which were modified since the
+// given time and match the stale patterns
+func parseListing(in Input, listing string, since time.Time) []string {
+	var files []string
+
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.SplitN(strings.TrimRight(line, "\r"), " ", 2)
+
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+			continue
+		}
+
+		seconds, err := strconv.ParseInt(fields[0], 10, 64)
+
+		// the times are truncated to the second
+		if err != nil || time.Unix(seconds, 0).Before(since.Truncate(time.Second)) {
+			continue
+		}
+
+		if matchesStale(in, fields[1]) {
+			files = append(files, fields[1])
+		}
+	}
+
+	return files
+}
+
+// mountSource describes the volume or bind mount a file was found in, which is the
+// one with the longest destination containing it
+func mountSource(mounts []types.MountPoint, file string) string {
+	var found types.MountPoint
+
+	for _, m := range mounts {
+		if isWithin(file, m.Destination) && len(m.Destination) > len(found.Destination) {
+			found = m
+		}
+	}
+
+	if found.Type == mount.TypeBind {
+		return "volume " + found.Source
+	}
+
+	return "volume " + found.Name
+}
+
+func matchesStale(in Input, name string) bool {
+	base := path.Base(name)
+
+	for _, pattern := range append(stalePatterns, in.StalePatterns...) {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeStaleFiles(writer io.Writer, data []Output) {
+	for _, out := range data {
+		for _, f := range out.StaleFiles {
+			fmt.Fprintf(writer, "\nHAZARD %s: %s was left behind in the %s and may prevent a restart\n", out.ShortID, f.Path, f.Source)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestParseListing(t *testing.T) {
+	since := time.Unix(1000, 500)
+
+	listing := "1000 /data/postmaster.pid\n" +
+		"999 /data/old.lock\n" +
+		"1200 /data/my file.sock\r\n" +
+		"1200 /data/notes.txt\n" +
+		"1200 /data/run.tmp\n" +
+		"find: /data/secret: Permission denied\n" +
+		"\n"
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{name: "built-in patterns", want: []string{"/data/postmaster.pid", "/data/my file.sock"}},
+		{name: "extra patterns", patterns: []string{"*.tmp"}, want: []string{"/data/postmaster.pid", "/data/my file.sock", "/data/run.tmp"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseListing(Input{StalePatterns: tt.patterns}, listing, since)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListing() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountSource(t *testing.T) {
+	mounts := []types.MountPoint{
+		{Type: mount.TypeVolume, Name: "data", Destination: "/var/lib/app"},
+		{Type: mount.TypeBind, Source: "/srv/run", Destination: "/var/lib/app/run"},
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "/var/lib/app/db.lock", want: "volume data"},
+		{file: "/var/lib/app/run/app.pid", want: "volume /srv/run"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			if got := mountSource(mounts, tt.file); got != tt.want {
+				t.Errorf("mountSource(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
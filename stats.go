@@ -0,0 +1,26 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// percentile returns the p-th percentile (0 to 100) of the given durations using the
+// nearest-rank method, or zero if there are none
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	durations := []time.Duration{5, 1, 4, 2, 3, 10, 9, 8, 7, 6}
+
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{name: "empty", p: 50, want: 0},
+		{name: "single", durations: []time.Duration{7}, p: 99, want: 7},
+		{name: "minimum", durations: durations, p: 0, want: 1},
+		{name: "median", durations: durations, p: 50, want: 5},
+		{name: "p95", durations: durations, p: 95, want: 10},
+		{name: "p90", durations: durations, p: 90, want: 9},
+		{name: "maximum", durations: durations, p: 100, want: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.durations, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %v) = %v, want %v", tt.durations, tt.p, got, tt.want)
+			}
+		})
+	}
+
+	if durations[0] != 5 {
+		t.Errorf("percentile sorted its input in place")
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+const (
+	defaultStopSignal = "SIGTERM"
+	killSignal        = "SIGKILL"
+)
+
+// signalNumbers are the numbers of the signals on Linux, which is where containers
+// run, whatever the platform grace runs on
+var signalNumbers = map[string]int{
+	"SIGHUP": 1, "SIGINT": 2, "SIGQUIT": 3, "SIGILL": 4, "SIGTRAP": 5, "SIGABRT": 6,
+	"SIGBUS": 7, "SIGFPE": 8, "SIGKILL": 9, "SIGUSR1": 10, "SIGSEGV": 11, "SIGUSR2": 12,
+	"SIGPIPE": 13, "SIGALRM": 14, "SIGTERM": 15, "SIGSTKFLT": 16, "SIGCHLD": 17,
+	"SIGCONT": 18, "SIGSTOP": 19, "SIGTSTP": 20, "SIGTTIN": 21, "SIGTTOU": 22,
+	"SIGURG": 23, "SIGXCPU": 24, "SIGXFSZ": 25, "SIGVTALRM": 26, "SIGPROF": 27,
+	"SIGWINCH": 28, "SIGIO": 29, "SIGPWR": 30, "SIGSYS": 31,
+}
+
+// Step is a single step of an escalation policy: a signal sent to the container,
+// followed by how long to wait for it to terminate before moving to the next step
+type Step struct {
+	Signal string
+	Wait   time.Duration
+}
+
+// Stop is the outcome of stopping a container with an escalation policy
+type Stop struct {
+	// Duration is the time between the first signal and the container exit
+	Duration time.Duration
+
+	// Timeout is the time the container was given before the final SIGKILL
+	Timeout time.Duration
+
+	// Signal is the signal of the step that ended the container, and Step its
+	// position in the policy, starting at 1
+	Signal string
+	Step   int
+
+	// Ignored is set when the first signal had no observable effect on the
+	// container: it neither logged anything nor changed its process list. A process
+	// handling the signal silently looks the same, unless its host /proc entry shows
+	// that it catches the signal.
+	Ignored bool
+
+	// Signaled is when the first signal was sent
+	Signaled time.Time
+
+	// Resent counts how many times the first signal was sent again before the
+	// container exited or the next step started
+	Resent int
+
+	Diagnostics *Diagnostics
+
+	// Sockets is only set if socket tracking was requested
+	Sockets *SocketLifecycle
+}
+
+// parseEscalation parses a policy such as "SIGTERM,5s,SIGINT,5s,SIGKILL", where each
+// signal may be followed by the time to wait for it. A final SIGKILL is added if the
+// policy does not end with one, so that the container is always stopped.
+func parseEscalation(s string) ([]Step, error) {
+	var steps []Step
+
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+
+		if term == "" {
+			continue
+		}
+
+		if wait, err := time.ParseDuration(term); err == nil {
+			if len(steps) == 0 || steps[len(steps)-1].Wait != 0 {
+				return nil, fmt.Errorf("escalation wait %q must follow a signal", term)
+			}
+
+			steps[len(steps)-1].Wait = wait
+			continue
+		}
+
+		steps = append(steps, Step{Signal: normalizeSignal(term)})
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("escalation policy %q has no signals", s)
+	}
+
+	for _, step := range steps[:len(steps)-1] {
+		if step.Wait == 0 {
+			return nil, fmt.Errorf("signal %s must be followed by a wait", step.Signal)
+		}
+	}
+
+	if last := steps[len(steps)-1]; last.Signal != killSignal {
+		if last.Wait == 0 {
+			return nil, fmt.Errorf("signal %s must be followed by a wait", last.Signal)
+		}
+
+		steps = append(steps, Step{Signal: killSignal})
+	}
+
+	return steps, nil
+}
+
+// defaultEscalation is what the container daemon does on its own: send the stop
+// signal, wait for the stop timeout and then send a SIGKILL
+func defaultEscalation(signal string, timeout time.Duration) []Step {
+	if signal == "" {
+		signal = defaultStopSignal
+	}
+
+	return []Step{
+		{Signal: normalizeSignal(signal), Wait: timeout},
+		{Signal: killSignal},
+	}
+}
+
+// normalizeSignal converts signal names such as "term" into "SIGTERM"; numeric
+// signals are left untouched
+func normalizeSignal(signal string) string {
+	signal = strings.ToUpper(strings.TrimSpace(signal))
+
+	if n, err := strconv.Atoi(signal); err == nil {
+		for name, number := range signalNumbers {
+			if number == n {
+				return name
+			}
+		}
+
+		// real-time signals have no name
+		return signal
+	}
+
+	if !strings.HasPrefix(signal, "SIG") {
+		signal = "SIG" + signal
+	}
+
+	return signal
+}
+
+// escalationTimeout is the total time a policy gives the container before the
+// final step
+func escalationTimeout(steps []Step) time.Duration {
+	var timeout time.Duration
+
+	for _, step := range steps[:len(steps)-1] {
+		timeout += step.Wait
+	}
+
+	return timeout
+}
+
+func stopContainer(ctx context.Context, in Input, c string, steps []Step) (stop Stop, err error) {
+	docker := in.Docker
+
+	stop.Timeout = escalationTimeout(steps)
+
+	// start waiting before the first signal, so we can't miss the exit
+	waitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	exited, failed := docker.ContainerWait(waitCtx, c, container.WaitConditionNotRunning)
+
+	before, err := docker.ContainerTop(ctx, c, nil)
+
+	if err != nil {
+		return stop, err
+	}
+
+	var diag *Diagnostics
+	var wg sync.WaitGroup
+
+	done := make(chan struct{})
+
+	var tracker *socketTracker
+
+	if in.TrackSockets {
+		if tracker, err = newSocketTracker(ctx, docker, c); err != nil {
+			return stop, err
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			tracker.run(done)
+		}()
+	}
+
+	if in.Diagnose > 0 {
+		wait := time.Duration(float64(stop.Timeout) * in.Diagnose)
+
+		wg.Add(1)
+
+		// collect diagnostics concurrently, unless the container stops first
+		go func() {
+			defer wg.Done()
+
+			select {
+			case <-done:
+			case <-time.After(wait):
+				diag = collectDiagnostics(ctx, docker, c, in.DiagnoseSignal, wait)
+			}
+		}()
+	}
+
+	defer func() {
+		close(done)
+		wg.Wait()
+
+		stop.Diagnostics = diag
+
+		if tracker != nil {
+			stop.Sockets = tracker.lifecycle(stop.Signaled)
+		}
+	}()
+
+	if len(in.Chaos) > 0 && in.ChaosAt == ChaosBefore {
+		restore, err := injectChaos(ctx, in, c)
+
+		if err != nil {
+			return stop, err
+		}
+
+		defer restore()
+	}
+
+	// background receives the outcome of each of the pending tasks running while
+	// the container stops, with room for all of them so that they never block
+	background := make(chan error, 2)
+	pending := 0
+
+	// drain waits for the pending tasks, which may still fail the stop
+	drain := func() error {
+		for ; pending > 0; pending-- {
+			if err := <-background; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	start := time.Now()
+
+	for i, step := range steps {
+		stop.Signal = step.Signal
+		stop.Step = i + 1
+
+		signaled := time.Now()
+
+		if err := docker.ContainerKill(ctx, c, step.Signal); err != nil {
+			if i == 0 {
+				return stop, err
+			}
+
+			// the container may have exited right before the signal, in which case
+			// the exit must be attributed to the previous step
+			select {
+			case <-exited:
+				stop.Signal = steps[i-1].Signal
+				stop.Step = i
+				stop.Duration = time.Since(start)
+				return stop, drain()
+			case <-time.After(time.Second):
+				return stop, err
+			}
+		}
+
+		if i == 0 {
+			stop.Signaled = signaled
+
+			event := HookEvent{Stage: HookAfterSignal, ContainerID: c, Signal: step.Signal, SignalTime: &signaled}
+
+			// hooks run while we wait, so that they do not give the container more time
+			pending++
+
+			go func() {
+				background <- runHooks(ctx, in, event)
+			}()
+		}
+
+		// chaos is injected while we wait, so that it neither gives the container
+		// more time nor delays noticing its exit
+		if i == 0 && len(in.Chaos) > 0 && in.ChaosAt == ChaosAfter {
+			injected := make(chan func(), 1)
+
+			pending++
+
+			go func() {
+				restore, err := injectChaos(ctx, in, c)
+
+				if err != nil {
+					restore = func() {}
+				}
+
+				injected <- restore
+				background <- err
+			}()
+
+			defer func() { (<-injected)() }()
+		}
+
+		// the final step has no wait, we wait for as long as it takes
+		var timeout <-chan time.Time
+
+		if i < len(steps)-1 {
+			timeout = time.After(time.Until(signaled.Add(step.Wait)))
+		}
+
+		// the first signal may be sent again while we wait, like an impatient
+		// orchestrator or human would do
+		var resend <-chan time.Time
+
+		if i == 0 && in.Resend > 0 {
+			ticker := time.NewTicker(in.ResendInterval)
+			defer ticker.Stop()
+
+			resend = ticker.C
+		}
+
+	wait:
+		for {
+			select {
+			case <-exited:
+				stop.Duration = time.Since(start)
+				return stop, drain()
+			case err := <-failed:
+				return stop, err
+			case err := <-background:
+				pending--
+
+				if err != nil {
+					return stop, err
+				}
+			case <-timeout:
+				break wait
+			case <-resend:
+				// the container may be exiting, which is what we are waiting for
+				if err := docker.ContainerKill(ctx, c, step.Signal); err == nil {
+					stop.Resent++
+				}
+
+				if stop.Resent >= in.Resend {
+					resend = nil
+				}
+			}
+		}
+
+		if i == 0 {
+			stop.Ignored = !observeEffect(ctx, docker, c, before, signaled) && !catchesSignal(ctx, docker, c, step.Signal)
+		}
+	}
+
+	return stop, nil
+}
+
+// observeEffect tells if a signal sent at the given time had any observable effect
+// on a container that is still running: either it logged something or its process
+// list changed
+func observeEffect(ctx context.Context, docker *client.Client, c string, before container.ContainerTopOKBody, signaled time.Time) bool {
+	logs, err := readLogs(ctx, docker, c, signaled)
+
+	// when in doubt, we assume the signal was handled
+	if err != nil || strings.TrimSpace(logs) != "" {
+		return true
+	}
+
+	return processesChanged(ctx, docker, c, before)
+}
+
+// processesChanged tells if the process list of a container changed since the given
+// one, assuming it did if it cannot be listed
+func processesChanged(ctx context.Context, docker *client.Client, c string, before container.ContainerTopOKBody) bool {
+	after, err := docker.ContainerTop(ctx, c, nil)
+
+	if err != nil {
+		return true
+	}
+
+	return !reflect.DeepEqual(processKeys(before), processKeys(after))
+}
+
+// catchesSignal tells if the main process of a container installed a handler for a
+// signal, which is only known when its host /proc entry is visible
+func catchesSignal(ctx context.Context, docker *client.Client, c string, signal string) bool {
+	json, err := docker.ContainerInspect(ctx, c)
+
+	if err != nil || json.State == nil || !hasHostProc(json.State.Pid) {
+		return false
+	}
+
+	caught, err := readCaughtSignals(json.State.Pid)
+
+	if err != nil {
+		return false
+	}
+
+	n, ok := signalNumbers[signal]
+
+	return ok && caught&(1<<uint(n-1)) != 0
+}
+
+// processKeys identifies each process of a process list by its PID and command,
+// ignoring the columns that change on their own, such as CPU time
+func processKeys(top container.ContainerTopOKBody) []string {
+	var keys []string
+
+	for _, row := range top.Processes {
+		var key []string
+
+		for i, title := range top.Titles {
+			if (title == "PID" || title == "CMD" || title == "COMMAND") && i < len(row) {
+				key = append(key, row[i])
+			}
+		}
+
+		keys = append(keys, strings.Join(key, " "))
+	}
+
+	return keys
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSignal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "SIGTERM", want: "SIGTERM"},
+		{in: "term", want: "SIGTERM"},
+		{in: " sigint ", want: "SIGINT"},
+		{in: "9", want: "SIGKILL"},
+		{in: "15", want: "SIGTERM"},
+		{in: "3", want: "SIGQUIT"},
+		{in: "34", want: "34"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := normalizeSignal(tt.in); got != tt.want {
+				t.Errorf("normalizeSignal(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEscalation(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Step
+		wantErr bool
+	}{
+		{
+			in:   "SIGTERM,5s,SIGINT,5s,SIGKILL",
+			want: []Step{{"SIGTERM", 5 * time.Second}, {"SIGINT", 5 * time.Second}, {killSignal, 0}},
+		},
+		{
+			in:   "SIGTERM,5s",
+			want: []Step{{"SIGTERM", 5 * time.Second}, {killSignal, 0}},
+		},
+		{
+			in:   "SIGTERM,5s,9",
+			want: []Step{{"SIGTERM", 5 * time.Second}, {killSignal, 0}},
+		},
+		{
+			in:   " int , 1m ,kill",
+			want: []Step{{"SIGINT", time.Minute}, {killSignal, 0}},
+		},
+		{
+			in:   "SIGKILL",
+			want: []Step{{killSignal, 0}},
+		},
+		{in: "", wantErr: true},
+		{in: "5s,SIGTERM", wantErr: true},
+		{in: "SIGTERM,5s,5s", wantErr: true},
+		{in: "SIGTERM,SIGKILL", wantErr: true},
+		{in: "SIGTERM", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseEscalation(tt.in)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEscalation(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEscalation(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
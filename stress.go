@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// defaultBurnerImage is used for CPU burners, it only needs a shell
+const defaultBurnerImage = "busybox"
+
+// Stress describes the resource pressure fresh containers are stopped under, the
+// zero value applies no pressure at all
+type Stress struct {
+	// NanoCPUs and Memory limit the fresh containers, in units of 10^-9 CPUs and
+	// bytes; swap is disabled whenever memory is limited
+	NanoCPUs int64
+	Memory   int64
+
+	// ReadBps and WriteBps throttle the fresh containers' access to block devices
+	ReadBps  []*blkiodev.ThrottleDevice
+	WriteBps []*blkiodev.ThrottleDevice
+
+	// Burners is how many containers spinning a CPU each run alongside the fresh
+	// container while it is stopped
+	Burners     int
+	BurnerImage string
+}
+
+// apply sets the resource limits of a fresh container
+func (s Stress) apply(hostConfig *container.HostConfig) {
+	if s.NanoCPUs > 0 {
+		hostConfig.NanoCPUs = s.NanoCPUs
+	}
+
+	if s.Memory > 0 {
+		hostConfig.Memory = s.Memory
+		hostConfig.MemorySwap = s.Memory
+	}
+
+	if len(s.ReadBps) > 0 {
+		hostConfig.BlkioDeviceReadBps = s.ReadBps
+	}
+
+	if len(s.WriteBps) > 0 {
+		hostConfig.BlkioDeviceWriteBps = s.WriteBps
+	}
+}
+
+// parseThrottleDevices parses device rates such as "/dev/sda:1mb"
+func parseThrottleDevices(values []string) ([]*blkiodev.ThrottleDevice, error) {
+	var devices []*blkiodev.ThrottleDevice
+
+	for _, value := range values {
+		i := strings.LastIndex(value, ":")
+
+		if i < 0 {
+			return nil, fmt.Errorf("device rate %q must be in the form DEVICE:RATE", value)
+		}
+
+		rate, err := units.RAMInBytes(value[i+1:])
+
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid rate in device rate %q", value)
+		}
+
+		devices = append(devices, &blkiodev.ThrottleDevice{
+			Path: value[:i],
+			Rate: uint64(rate),
+		})
+	}
+
+	return devices, nil
+}
+
+// startBurners starts the CPU burners of a stress, which must be removed with
+// stopBurners once the measurement is over
+func startBurners(ctx context.Context, in Input) ([]string, error) {
+	var burners []string
+
+	if in.Stress.Burners == 0 {
+		return nil, nil
+	}
+
+	if err := pullImage(ctx, in.Docker, in.Stress.BurnerImage); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < in.Stress.Burners; i++ {
+		created, err := in.Docker.ContainerCreate(ctx, &container.Config{
+			Image: in.Stress.BurnerImage,
+			Cmd:   []string{"sh", "-c", "while :; do :; done"},
+		}, &container.HostConfig{}, nil, nil, "")
+
+		if err != nil {
+			stopBurners(ctx, in, burners)
+			return nil, err
+		}
+
+		burners = append(burners, created.ID)
+
+		if err := in.Docker.ContainerStart(ctx, created.ID, types.ContainerStartOptions{}); err != nil {
+			stopBurners(ctx, in, burners)
+			return nil, err
+		}
+	}
+
+	return burners, nil
+}
+
+func stopBurners(ctx context.Context, in Input, burners []string) {
+	for _, b := range burners {
+		_ = in.Docker.ContainerRemove(ctx, b, types.ContainerRemoveOptions{Force: true})
+	}
+}
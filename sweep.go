@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Sweep holds the options of the sweep command
+type Sweep struct {
+	// Trials is how many times each timeout is tested, all of them must succeed
+	Trials int
+
+	// Min and Max bound the searched timeouts, Precision is the step between them
+	Min       time.Duration
+	Max       time.Duration
+	Precision time.Duration
+
+	// Headroom is added on top of the measured timeout in the recommendation, as a
+	// fraction of it
+	Headroom float64
+}
+
+// SweepOutput is the outcome of a sweep for a single target
+type SweepOutput struct {
+	Target string
+
+	// Timeout is the smallest timeout that yielded GracefulSuccess across all trials,
+	// zero if not even Max did
+	Timeout time.Duration
+
+	// Stops are the results of every container stopped during the sweep
+	Stops []Output
+
+	// P99 is the 99th percentile of the shutdown durations of the containers that
+	// terminated gracefully
+	P99 time.Duration
+
+	Recommended time.Duration
+}
+
+var sweepFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  "trials",
+		Usage: "stop `N` fresh containers for each timeout, all of them must terminate gracefully",
+		Value: 3,
+	},
+	&cli.DurationFlag{
+		Name:  "min",
+		Usage: "smallest stop `DURATION` to try",
+		Value: time.Second,
+	},
+	&cli.DurationFlag{
+		Name:  "max",
+		Usage: "largest stop `DURATION` to try",
+		Value: time.Minute,
+	},
+	&cli.DurationFlag{
+		Name:  "precision",
+		Usage: "stop searching once the timeouts are this `DURATION` apart",
+		Value: time.Second,
+	},
+	&cli.Float64Flag{
+		Name:  "headroom",
+		Usage: "add this `FRACTION` of the measured timeout to the recommendation",
+		Value: 0.5,
+	},
+}
+
+var sweepCommand = &cli.Command{
+	Name:      "sweep",
+	Usage:     "recommends the smallest safe stop timeout for each container or image",
+	UsageText: "grace sweep --clone|--image [OPTIONS] [CONTAINER|IMAGE [CONTAINER|IMAGE ...]]",
+	Flags:     commandFlags(sweepFlags, runFlags),
+	Action: func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			cli.ShowCommandHelpAndExit(c, "sweep", 0)
+		}
+
+		in, err := parseInput(c)
+
+		if err != nil {
+			return err
+		}
+
+		if !in.Mode.fresh() {
+			return fmt.Errorf("sweep requires either clone or image")
+		}
+
+		sweep := Sweep{
+			Trials:    c.Int("trials"),
+			Min:       c.Duration("min"),
+			Max:       c.Duration("max"),
+			Precision: c.Duration("precision"),
+			Headroom:  c.Float64("headroom"),
+		}
+
+		if sweep.Trials < 1 || sweep.Min <= 0 || sweep.Max < sweep.Min || sweep.Precision <= 0 {
+			return fmt.Errorf("sweep needs at least one trial, 0 < min <= max and a positive precision")
+		}
+
+		return runSweep(in, sweep, os.Stdout)
+	},
+}
+
+func runSweep(in Input, sweep Sweep, writer io.Writer) error {
+	var data []SweepOutput
+
+	for _, target := range in.Containers {
+		out, err := sweepTarget(context.Background(), in, sweep, target)
+
+		if err != nil {
+			return err
+		}
+
+		data = append(data, out)
+	}
+
+	writeSweep(writer, data)
+
+	var all []Output
+
+	for _, out := range data {
+		all = append(all, out.Stops...)
+	}
+
+	return afterReport(context.Background(), in, all)
+}
+
+// sweepTarget bisects the stop timeout of a target, assuming that if a timeout is
+// enough for the container to terminate gracefully, so is any larger one
+func sweepTarget(ctx context.Context, in Input, sweep Sweep, target string) (SweepOutput, error) {
+	out := SweepOutput{Target: target}
+
+	var durations []time.Duration
+
+	// trial stops fresh containers with the given timeout until one of them fails
+	trial := func(timeout time.Duration) (bool, error) {
+		run := in
+		run.Timeout = timeout
+
+		for i := 0; i < sweep.Trials; i++ {
+			result, err := runOnce(ctx, run, target)
+
+			if err != nil {
+				return false, err
+			}
+
+			out.Stops = append(out.Stops, result)
+
+			if result.Termination != GracefulSuccess {
+				return false, nil
+			}
+
+			durations = append(durations, result.StopDuration)
+		}
+
+		return true, nil
+	}
+
+	ok, err := trial(sweep.Max)
+
+	if err != nil || !ok {
+		return out, err
+	}
+
+	lo, hi := sweep.Min, sweep.Max
+
+	if ok, err = trial(lo); err != nil {
+		return out, err
+	}
+
+	if ok {
+		hi = lo
+	}
+
+	// invariant: lo fails and hi succeeds
+	for hi-lo > sweep.Precision {
+		mid := lo + (hi-lo)/2
+		mid -= mid % sweep.Precision
+
+		if mid <= lo {
+			mid = lo + sweep.Precision
+		}
+
+		ok, err := trial(mid)
+
+		if err != nil {
+			return out, err
+		}
+
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	out.Timeout = hi
+	out.P99 = percentile(durations, 99)
+	out.Recommended = recommendTimeout(out.Timeout, out.P99, sweep.Headroom)
+
+	return out, nil
+}
+
+// recommendTimeout adds headroom on top of the largest of the measured timeout and
+// shutdown duration, rounded up to whole seconds as platforms expect
+func recommendTimeout(timeout, p99 time.Duration, headroom float64) time.Duration {
+	base := timeout
+
+	if p99 > base {
+		base = p99
+	}
+
+	seconds := math.Ceil(base.Seconds() * (1 + headroom))
+
+	return time.Duration(seconds) * time.Second
+}
+
+func writeSweep(writer io.Writer, data []SweepOutput) {
+	table := newTable(writer)
+
+	var rows [][]string
+
+	for _, out := range data {
+		timeout, recommended := "none", "none"
+
+		if out.Timeout > 0 {
+			timeout = out.Timeout.String()
+			recommended = out.Recommended.String()
+		}
+
+		rows = append(rows, []string{
+			out.Target,
+			timeout,
+			strconv.Itoa(len(out.Stops)),
+			out.P99.Round(time.Millisecond).String(),
+			recommended,
+		})
+	}
+
+	table.SetHeader([]string{
+		"TARGET", "MIN TIMEOUT", "RUNS", "P99 SHUTDOWN", "RECOMMENDED",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, out := range data {
+		if out.Timeout == 0 {
+			fmt.Fprintf(writer, "\n%s never terminated gracefully, not even with the largest timeout\n", out.Target)
+			continue
+		}
+
+		seconds := int64(out.Recommended / time.Second)
+
+		fmt.Fprintf(writer, "\n%s:\n  stop_grace_period: %ds\n  terminationGracePeriodSeconds: %d\n", out.Target, seconds, seconds)
+	}
+}
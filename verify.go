@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+const (
+	// verifyTimeout is how long the verification container may run
+	verifyTimeout = time.Minute
+
+	// maxVerifiedFileSize is how much of a file is read to match its content
+	maxVerifiedFileSize = 1 << 20
+)
+
+// Verify is what is checked once a container exited, since exiting with code zero
+// doesn't prove the application flushed its state
+type Verify struct {
+	Files []FileAssertion
+
+	// Image, if set, is run with the volumes of the stopped container, and the stop
+	// is only verified if it exits with code zero. Command replaces its command.
+	Image   string
+	Command string
+}
+
+func (v Verify) configured() bool {
+	return len(v.Files) > 0 || v.Image != ""
+}
+
+// FileAssertion is an assertion on a file of the stopped container: it must exist,
+// not exist if Absent, or have content matching Match if set
+type FileAssertion struct {
+	Path   string
+	Absent bool
+	Match  *regexp.Regexp
+}
+
+func (a FileAssertion) String() string {
+	switch {
+	case a.Absent:
+		return "!" + a.Path
+	case a.Match != nil:
+		return a.Path + "=" + a.Match.String()
+	}
+
+	return a.Path
+}
+
+// Verification is the outcome of verifying a stop
+type Verification struct {
+	// Failures are the assertions that did not hold, the stop is verified if there
+	// are none
+	Failures []string
+
+	// Logs are what the verification container logged, if it failed
+	Logs string
+}
+
+// parseFileAssertions parses assertions such as "/data/clean", "!/run/app.pid" or
+// "/data/state=^shutdown: clean$"
+func parseFileAssertions(values []string) ([]FileAssertion, error) {
+	var assertions []FileAssertion
+
+	for _, value := range values {
+		var a FileAssertion
+
+		if strings.HasPrefix(value, "!") {
+			a.Absent = true
+			value = strings.TrimPrefix(value, "!")
+		}
+
+		parts := strings.SplitN(value, "=", 2)
+		a.Path = parts[0]
+
+		if !strings.HasPrefix(a.Path, "/") {
+			return nil, fmt.Errorf("verified file %q must be an absolute path", a.Path)
+		}
+
+		if len(parts) == 2 {
+			if a.Absent {
+				return nil, fmt.Errorf("verified file %q cannot both be absent and match a pattern", a.Path)
+			}
+
+			re, err := regexp.Compile("(?m)" + parts[1])
+
+			if err != nil {
+				return nil, err
+			}
+
+			a.Match = re
+		}
+
+		assertions = append(assertions, a)
+	}
+
+	return assertions, nil
+}
+
+// verifyStop checks the files of an exited container and runs the verification
+// container, if any. Problems that prevent the checks are reported as failures.
+func verifyStop(ctx context.Context, in Input, c string) *Verification {
+	v := &Verification{}
+
+	for _, a := range in.Verify.Files {
+		if err := a.check(ctx, in, c); err != nil {
+			v.Failures = append(v.Failures, err.Error())
+		}
+	}
+
+	if in.Verify.Image != "" {
+		logs, err := runVerifier(ctx, in, c)
+
+		if err != nil {
+			v.Failures = append(v.Failures, err.Error())
+			v.Logs = logs
+		}
+	}
+
+	return v
+}
+
+func (a FileAssertion) check(ctx context.Context, in Input, c string) error {
+	content, _, err := in.Docker.CopyFromContainer(ctx, c, a.Path)
+
+	if errdefs.IsNotFound(err) {
+		if a.Absent {
+			return nil
+		}
+
+		return fmt.Errorf("%s is missing", a.Path)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer content.Close()
+
+	if a.Absent {
+		return fmt.Errorf("%s is still present", a.Path)
+	}
+
+	if a.Match == nil {
+		return nil
+	}
+
+	// files are copied as a tar archive, with the file itself as the first entry
+	archive := tar.NewReader(content)
+
+	header, err := archive.Next()
+
+	if err != nil {
+		return err
+	}
+
+	if header.Typeflag != tar.TypeReg {
+		return fmt.Errorf("%s is not a regular file", a.Path)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(archive, maxVerifiedFileSize))
+
+	if err != nil {
+		return err
+	}
+
+	if !a.Match.Match(data) {
+		return fmt.Errorf("%s does not match %q", a.Path, strings.TrimPrefix(a.Match.String(), "(?m)"))
+	}
+
+	return nil
+}
+
+// runVerifier runs the verification container with the volumes of the stopped
+// container, and returns its logs
+func runVerifier(ctx context.Context, in Input, c string) (string, error) {
+	docker := in.Docker
+
+	if err := pullImage(ctx, docker, in.Verify.Image); err != nil {
+		return "", err
+	}
+
+	config := &container.Config{
+		Image: in.Verify.Image,
+		Env:   []string{"GRACE_CONTAINER_ID=" + c},
+	}
+
+	// the command replaces the entrypoint of the image, rather than being passed to
+	// it as arguments
+	if in.Verify.Command != "" {
+		config.Entrypoint = []string{"sh", "-c", in.Verify.Command}
+	}
+
+	hostConfig := &container.HostConfig{VolumesFrom: []string{c}}
+
+	logs, code, err := runToCompletion(ctx, docker, config, hostConfig, verifyTimeout)
+
+	if err != nil {
+		return "", fmt.Errorf("verification container failed: %w", err)
+	}
+
+	if code != 0 {
+		return strings.TrimSpace(logs), fmt.Errorf("verification container exited with code %d", code)
+	}
+
+	return logs, nil
+}
+
+func writeVerification(writer io.Writer, data []Output) {
+	var rows [][]string
+
+	for _, out := range data {
+		if out.Verification == nil {
+			continue
+		}
+
+		result := "ok"
+
+		if n := len(out.Verification.Failures); n > 0 {
+			result = strconv.Itoa(n) + " failed"
+		}
+
+		rows = append(rows, []string{out.ShortID, result})
+	}
+
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintln(writer)
+
+	table := newTable(writer)
+
+	table.SetHeader([]string{
+		"ID", "VERIFICATION",
+	})
+
+	table.AppendBulk(rows)
+	table.Render()
+
+	for _, out := range data {
+		if out.Verification == nil {
+			continue
+		}
+
+		for _, failure := range out.Verification.Failures {
+			fmt.Fprintf(writer, "\nUNVERIFIED %s: %s\n", out.ShortID, failure)
+		}
+
+		if out.Verification.Logs != "" {
+			fmt.Fprintf(writer, "\n%s\n", out.Verification.Logs)
+		}
+	}
+}
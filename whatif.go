@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// whatIfTimeoutFactor is how much longer than the failed stop the stop timeout
+// remedy waits
+const whatIfTimeoutFactor = 3
+
+// whatIfSignals are the stop signals tried instead of the one that failed
+var whatIfSignals = []string{"SIGTERM", "SIGINT", "SIGQUIT", "SIGHUP"}
+
+// WhatIf is the outcome of stopping a fresh container of a target with a candidate
+// fix applied
+type WhatIf struct {
+	// Remedy is the change made to the container, and Fix how to make it permanent
+	Remedy string
+	Fix    string
+
+	// Output is nil if the container could not be started or stopped, as told by
+	// Error
+	Output *Output
+	Error  string
+}
+
+// remedy is a candidate fix, applied to the configuration of a fresh container and
+// to the input it is stopped with
+type remedy struct {
+	name  string
+	fix   string
+	apply func(config *container.Config, hostConfig *container.HostConfig, in *Input)
+}
+
+// remediable tells if a termination may be fixed by changing how the container is
+// configured or stopped
+func (d Termination) remediable() bool {
+	return d == ForceKilled || d == SignalIgnored
+}
+
+// remedies lists the candidate fixes for a container that failed to stop, the least
+// invasive first
+func remedies(config *container.Config, hostConfig *container.HostConfig, failed Output) []remedy {
+	var candidates []remedy
+
+	command := append(append([]string{}, config.Entrypoint...), config.Cmd...)
+
+	// commands using shell syntax cannot be written in exec form
+	if isShellForm(config.Entrypoint) || (len(config.Entrypoint) == 0 && isShellForm(config.Cmd)) {
+		if words, ok := shellWords(command[2]); ok && len(words) > 0 {
+			candidates = append(candidates, remedy{
+				name: "exec form",
+				fix:  "use the exec form: ENTRYPOINT " + execFormString(words),
+				apply: func(config *container.Config, _ *container.HostConfig, _ *Input) {
+					config.Entrypoint = words
+					config.Cmd = nil
+				},
+			})
+		}
+	}
+
+	if len(command) > 0 && !isInit(command[0]) && (hostConfig.Init == nil || !*hostConfig.Init) {
+		candidates = append(candidates, remedy{
+			name: "init",
+			fix:  "run an init process as PID 1: docker run --init, or tini in the ENTRYPOINT",
+			apply: func(_ *container.Config, hostConfig *container.HostConfig, _ *Input) {
+				init := true
+				hostConfig.Init = &init
+			},
+		})
+	}
+
+	for _, signal := range whatIfSignals {
+		if signal == failed.FirstSignal {
+			continue
+		}
+
+		signal := signal
+
+		candidates = append(candidates, remedy{
+			name: "stop signal " + signal,
+			fix:  "STOPSIGNAL " + signal,
+			apply: func(config *container.Config, _ *container.HostConfig, in *Input) {
+				config.StopSignal = signal
+				in.Signal = signal
+			},
+		})
+	}
+
+	// a longer timeout hides slow shutdowns rather than fixing them, so it comes last
+	timeout := failed.Timeout * whatIfTimeoutFactor
+	seconds := int(timeout / time.Second)
+
+	candidates = append(candidates, remedy{
+		name: "stop timeout " + timeout.String(),
+		fix:  fmt.Sprintf("raise the stop timeout to %s: docker run --stop-timeout %d, or the grace period of the platform", timeout, seconds),
+		apply: func(config *container.Config, _ *container.HostConfig, in *Input) {
+			config.StopTimeout = &seconds
+			in.Timeout = timeout
+		},
+	})
+
+	return candidates
+}
+
+// whatIf stops fresh containers of a target that failed to stop gracefully, each
+// with a candidate fix, leaving the target itself untouched. Errors are reported
+// with the candidates, so that they don't stop the other targets.
+func whatIf(ctx context.Context, in Input, target string, failed Output) []WhatIf {
+	// every candidate gets its own copy of the configuration
+	base := func() (*container.Config, *container.HostConfig, error) {
+		config, hostConfig, err := targetConfig(ctx, in, target)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// images only tell their command once inspected
+		if len(config.Entrypoint) == 0 && len(config.Cmd) == 0 {
+			inspect, _, err := in.Docker.ImageInspectWithRaw(ctx, config.Image)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if inspect.Config != nil {
+				config.Entrypoint = inspect.Config.Entrypoint
+				config.Cmd = inspect.Config.Cmd
+			}
+		}
+
+		return config, hostConfig, nil
+	}
+
+	config, hostConfig, err := base()
+
+	// there is no fresh container to try the candidates on
+	if err != nil {
+		return []WhatIf{{Remedy: "-", Error: err.Error()}}
+	}
+
+	var results []WhatIf
+
+	for _, r := range remedies(config, hostConfig, failed) {
+		result := WhatIf{Remedy: r.name, Fix: r.fix}
+
+		config, hostConfig, err := base()
+
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		run := in
+		run.Mode = Clone
+
+		r.apply(config, hostConfig, &run)
+
+		out, err := stopRemedied(ctx, run, target, config, hostConfig)
+
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Output = &out
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// stopRemedied starts a fresh container with the given configuration and stops it
+func stopRemedied(ctx context.Context, in Input, target string, config *container.Config, hostConfig *container.HostConfig) (Output, error) {
+	c, err := start(ctx, in, config, hostConfig)
+
+	if err != nil {
+		return Output{}, err
+	}
+
+	if err := waitStartup(ctx, in, c); err != nil {
+		cleanup(ctx, in, c)
+		return Output{}, err
+	}
+
+	return stopProvisioned(ctx, in, target, c)
+}
+
+func writeWhatIf(writer io.Writer, data []Output) {
+	for _, out := range data {
+		if len(out.WhatIf) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(writer, "\nWhat if %s had been stopped differently:\n\n", out.ShortID)
+
+		table := newTable(writer)
+		table.SetHeader([]string{"REMEDY", "TERMINATION", "SIGNAL", "EXIT CODE", "DURATION"})
+
+		var recommended *WhatIf
+		var tested int
+
+		for i, w := range out.WhatIf {
+			if w.Output == nil {
+				table.Append([]string{w.Remedy, "error: " + w.Error, "-", "-", "-"})
+				continue
+			}
+
+			tested++
+
+			if w.Output.Termination == GracefulSuccess && recommended == nil {
+				recommended = &out.WhatIf[i]
+			}
+
+			table.Append([]string{
+				w.Remedy,
+				w.Output.Termination.String(),
+				w.Output.Signal,
+				strconv.Itoa(w.Output.ExitCode),
+				fmt.Sprintf("%3ss/%ss", strconv.FormatInt(int64(w.Output.StopDuration/time.Second), 10), strconv.FormatInt(int64(w.Output.Timeout/time.Second), 10)),
+			})
+		}
+
+		table.Render()
+
+		switch {
+		case tested == 0:
+			fmt.Fprintf(writer, "\nNO REMEDY %s: no candidate fix could be tested\n", out.ShortID)
+			continue
+		case recommended == nil:
+			fmt.Fprintf(writer, "\nNO REMEDY %s: none of the %d candidate fixes tested terminated gracefully\n", out.ShortID, tested)
+			continue
+		}
+
+		var others []string
+
+		for _, w := range out.WhatIf {
+			if w.Output != nil && w.Output.Termination == GracefulSuccess && w.Remedy != recommended.Remedy {
+				others = append(others, w.Remedy)
+			}
+		}
+
+		fmt.Fprintf(writer, "\nRECOMMENDATION %s: %s\n", out.ShortID, recommended.Fix)
+
+		if len(others) > 0 {
+			fmt.Fprintf(writer, "  also tested gracefully: %s\n", strings.Join(others, ", "))
+		}
+	}
+}